@@ -0,0 +1,209 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/om/api"
+)
+
+const apiRetries = 3
+
+// apiRetryableErrors are the request errors, matched as substrings, that a
+// GET is retried on.
+var apiRetryableErrors = []string{
+	"connection reset by peer",
+	"EOF",
+}
+
+// APIError is returned when an Ops Manager API request gets an unexpected
+// response. Message holds the errors Ops Manager reported, if any.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e APIError) Error() string {
+	msg := fmt.Sprintf("%s %s failed with status %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += fmt.Sprintf(": %s", e.Message)
+	}
+
+	return msg
+}
+
+// UnauthorizedError is returned for a 401 response, usually because the Ops
+// Manager credentials are wrong.
+type UnauthorizedError struct {
+	APIError
+}
+
+// ForbiddenError is returned for a 403 response, when the Ops Manager user
+// may not use the endpoint.
+type ForbiddenError struct {
+	APIError
+}
+
+// NotFoundError is returned for a 404 response, which older Ops Managers
+// give for endpoints they do not have.
+type NotFoundError struct {
+	APIError
+}
+
+// ServerError is returned for a 5xx response.
+type ServerError struct {
+	APIError
+}
+
+// getJSON GETs path from the Ops Manager API and decodes its JSON response
+// into v. Server errors and dropped connections are retried with backoff, as
+// a GET can safely be repeated.
+func getJSON(rs requestService, path string, v interface{}) error {
+	retry := retryPolicy{retries: apiRetries, delay: defaultRetryDelay}
+
+	for attempt := 1; ; attempt++ {
+		body, err := get(rs, path)
+		if err == nil {
+			if err := json.Unmarshal(body, v); err != nil {
+				return fmt.Errorf("could not decode the response to GET %s: %s", path, err)
+			}
+			return nil
+		}
+
+		if attempt > retry.retries || !retryableAPIError(err) {
+			return err
+		}
+
+		time.Sleep(retry.backoff(attempt))
+	}
+}
+
+func get(rs requestService, path string) ([]byte, error) {
+	output, err := rs.Invoke(api.RequestServiceInvokeInput{
+		Path:   path,
+		Method: "GET",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GET %s failed: %s", path, err)
+	}
+	if closer, ok := output.Body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var body []byte
+	if output.Body != nil {
+		body, err = ioutil.ReadAll(output.Body)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the response to GET %s: %s", path, err)
+		}
+	}
+
+	if output.StatusCode >= 200 && output.StatusCode < 300 {
+		return body, nil
+	}
+
+	apiErr := APIError{
+		Method:     "GET",
+		Path:       path,
+		StatusCode: output.StatusCode,
+		Message:    apiErrorMessage(body),
+	}
+
+	switch {
+	case output.StatusCode == http.StatusUnauthorized:
+		return nil, UnauthorizedError{apiErr}
+	case output.StatusCode == http.StatusForbidden:
+		return nil, ForbiddenError{apiErr}
+	case output.StatusCode == http.StatusNotFound:
+		return nil, NotFoundError{apiErr}
+	case output.StatusCode >= 500:
+		return nil, ServerError{apiErr}
+	default:
+		return nil, apiErr
+	}
+}
+
+// retryableAPIError reports whether a failed request is worth repeating: 5xx
+// responses and reset connections are, other responses never are, whatever
+// their message says.
+func retryableAPIError(err error) bool {
+	switch err.(type) {
+	case ServerError:
+		return true
+	case UnauthorizedError, ForbiddenError, NotFoundError, APIError:
+		return false
+	}
+
+	for _, s := range apiRetryableErrors {
+		if strings.Contains(err.Error(), s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apiErrorMessage extracts the errors from an Ops Manager error response,
+// which are either a list or lists keyed by field:
+//
+//	{"errors": ["..."]}
+//	{"errors": {"base": ["..."]}}
+//
+// Bodies in any other form, such as HTML error pages, give no message.
+func apiErrorMessage(body []byte) string {
+	var response struct {
+		Errors json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Errors) == 0 {
+		return ""
+	}
+
+	var list []string
+	if err := json.Unmarshal(response.Errors, &list); err == nil {
+		return strings.Join(list, "; ")
+	}
+
+	var fields map[string][]string
+	if err := json.Unmarshal(response.Errors, &fields); err == nil {
+		var keys []string
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			for _, msg := range fields[key] {
+				if key != "base" {
+					msg = key + " " + msg
+				}
+				list = append(list, msg)
+			}
+		}
+		return strings.Join(list, "; ")
+	}
+
+	return ""
+}
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// BatchError is returned when any command in a batch failed. Err is the
+// first failure.
+type BatchError struct {
+	Failed int
+	Total  int
+	Err    error
+}
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("%d of %d bosh commands failed, the first with: %s", e.Failed, e.Total, e.Err)
+}
+
+func (e BatchError) Unwrap() error {
+	return e.Err
+}
+
+// readBatch reads bosh commands, one per line, from path or from stdin when
+// path is "-". Blank lines and lines starting with # are skipped.
+func readBatch(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read commands file: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var batch []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		batch = append(batch, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read commands file: %s", err)
+	}
+
+	if len(batch) == 0 {
+		return nil, fmt.Errorf("no bosh commands found in %s", path)
+	}
+
+	return batch, nil
+}
+
+// executeBatch runs each bosh command in its own session over a single ssh
+// connection. Unless continuing on failure was asked for, it stops at the
+// first command that fails. Interrupts and lost connections always stop it.
+func (b Bosh) executeBatch(input ExecuteOnRemoteInput, batch []string) error {
+	conn, err := b.ssh.Connect(input)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var (
+		statuses = make([]string, len(batch))
+		failed   int
+		firstErr error
+	)
+
+	for i, command := range batch {
+		b.stderr.Printf("[%d/%d] bosh %s\n", i+1, len(batch), command)
+
+		input.RawCommand = command
+		start := time.Now()
+		err := conn.ExecuteOnRemote(input)
+		took := time.Since(start) / time.Millisecond * time.Millisecond
+
+		if err == nil {
+			statuses[i] = "ok"
+			b.stderr.Printf("[%d/%d] succeeded in %s\n", i+1, len(batch), took)
+			continue
+		}
+
+		statuses[i] = "failed"
+		b.stderr.Printf("[%d/%d] failed in %s: %s\n", i+1, len(batch), took, err)
+
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if !b.Options.ContinueOnFailure || !canContinue(err) {
+			break
+		}
+	}
+
+	b.stderr.Printf("Summary:\n")
+	for i, command := range batch {
+		status := statuses[i]
+		if status == "" {
+			status = "skipped"
+		}
+		b.stderr.Printf("  %-8s bosh %s\n", status, command)
+	}
+
+	if failed > 0 {
+		return BatchError{Failed: failed, Total: len(batch), Err: firstErr}
+	}
+
+	return nil
+}
+
+// canContinue reports whether the connection is still usable after err and
+// the user has not asked to stop.
+func canContinue(err error) bool {
+	switch err.(type) {
+	case InterruptedError, ConnectionLostError, SessionError:
+		return false
+	default:
+		return true
+	}
+}
@@ -16,15 +16,17 @@
 package commands
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"time"
 
 	"github.com/pivotal-cf/om/api"
 	"github.com/pivotal-cf/om/commands"
-	"github.com/pivotal-cf/om/flags"
 )
 
+// rootCACertificatePath is where Ops Manager keeps the CA that signed the
+// director's certificate.
+const rootCACertificatePath = "/var/tempest/workspaces/default/root_ca_certificate"
+
 type requestService interface {
 	Invoke(api.RequestServiceInvokeInput) (api.RequestServiceInvokeOutput, error)
 }
@@ -39,18 +41,36 @@ type Bosh struct {
 	stdout         logger
 	stderr         logger
 	host           string
+	SSHOptions     SSHOptions
 	Options        struct {
-		SSHKeyPath  string `short:"i" long:"ssh-key-path" description:"path to ssh key"`
-		SSHPassword string `long:"ssh-password" description:"opsman ssh password"`
-		ProductName string `short:"p" long:"product-name" description:"Product name"`
-		Command     string `short:"c" long:"command"      description:"bosh command to execute"`
+		ProductName       string        `short:"p" long:"product-name" description:"Product name"`
+		InstallationName  string        `long:"installation-name" description:"installation name of the product, for product types deployed more than once"`
+		ProductGUID       string        `long:"product-guid" description:"GUID of the product, for product types deployed more than once"`
+		Command           string        `short:"c" long:"command"      description:"bosh command to execute, interpreted by the remote shell; alternatively pass the bosh arguments after --"`
+		CommandsFile      string        `long:"commands-file" description:"file of bosh commands, one per line, to run over a single connection; - reads them from stdin"`
+		ContinueOnFailure bool          `long:"continue-on-failure" description:"with --commands-file, run the remaining commands after one fails"`
+		Interactive       bool          `short:"t" long:"interactive"  description:"run the bosh command in a terminal, for bosh ssh, logs -f and confirmation prompts"`
+		GracePeriod       time.Duration `long:"interrupt-grace-period" description:"how long to wait for bosh to stop after an interrupt before closing the session" default:"10s"`
+		BoshCLI           string        `long:"bosh-cli" description:"bosh CLI generation to run: v1 (Ruby), v2 (Go) or auto to pick it from the Ops Manager version" default:"auto"`
 	}
 }
 
 type Products struct {
-	Name string `json:"installation_name"`
-	Guid string `json:"guid"`
-	Type string `json:"type"`
+	Name    string `json:"installation_name"`
+	Guid    string `json:"guid"`
+	Type    string `json:"type"`
+	Version string `json:"product_version"`
+}
+
+// deploymentName is the name of the product's bosh deployment, which Ops
+// Manager names after the product GUID. It is empty for the director, which
+// is not deployed by bosh.
+func (p Products) deploymentName() string {
+	if p.Type == "p-bosh" {
+		return ""
+	}
+
+	return p.Guid
 }
 
 type DirectorManifest struct {
@@ -80,107 +100,105 @@ func (b Bosh) Usage() commands.Usage {
 	return commands.Usage{
 		Description:      "Runs a bosh command from the OpsManager VM",
 		ShortDescription: "Runs a bosh command from the OpsManager VM",
-		Flags:            b.Options,
+		Flags:            usageFlags(&b.SSHOptions, &b.Options),
 	}
 }
 
 func (b Bosh) Execute(args []string) error {
-	_, err := flags.Parse(&b.Options, args)
+	boshArgs, err := parseFlags(args, &b.SSHOptions, &b.Options)
 	if err != nil {
 		return fmt.Errorf("could not parse curl flags: %s", err)
 	}
 
-	if b.Options.SSHKeyPath == "" && b.Options.SSHPassword == "" {
-		return fmt.Errorf("either ssh key path or the opsman ssh password must be provided")
+	if b.Options.Command != "" && len(boshArgs) > 0 {
+		return fmt.Errorf("the bosh command may be given either with --command or after --, not both")
 	}
 
-	manifest, err := b.getDirectorManifest()
-	if err != nil {
-		return err
+	switch b.Options.BoshCLI {
+	case boshCLIAuto, boshCLIv1, boshCLIv2:
+	default:
+		return fmt.Errorf("unknown bosh CLI %q: expected v1, v2 or auto", b.Options.BoshCLI)
 	}
 
-	boshCmd := []string{
-		"bundle exec bosh", "-n",
-		"--ca-cert /var/tempest/workspaces/default/root_ca_certificate",
-		fmt.Sprintf("-t %s", manifest.Jobs[0].Properties.Director.Address),
+	selector := productSelector{
+		Type:             b.Options.ProductName,
+		InstallationName: b.Options.InstallationName,
+		GUID:             b.Options.ProductGUID,
 	}
+	if err := selector.validate(); err != nil {
+		return err
+	}
+
+	var batch []string
+	if b.Options.CommandsFile != "" {
+		if b.Options.Command != "" || len(boshArgs) > 0 {
+			return fmt.Errorf("bosh commands may not be given along with --commands-file")
+		}
+		if b.Options.Interactive {
+			return fmt.Errorf("--interactive may not be given along with --commands-file")
+		}
 
-	var productId string
-	if b.Options.ProductName != "" {
-		productId, err = b.getProductId()
+		batch, err = readBatch(b.Options.CommandsFile)
 		if err != nil {
 			return err
 		}
-		boshCmd = append(boshCmd, fmt.Sprintf("-d /var/tempest/workspaces/default/deployments/%s.yml", productId))
-	}
-
-	boshEnv := []string{
-		`BOSH_CLIENT="ops_manager"`,
-		fmt.Sprintf(`BOSH_CLIENT_SECRET="%s"`, manifest.Jobs[0].Properties.Uaa.Clients.OpsManager.Secret),
-		"BUNDLE_GEMFILE=/home/tempest-web/tempest/web/vendor/bosh/Gemfile",
-	}
-
-	boshCmd = append(boshCmd, b.Options.Command)
-
-	return b.ssh.ExecuteOnRemote(ExecuteOnRemoteInput{
-		Host:        b.host,
-		SSHKeyPath:  b.Options.SSHKeyPath,
-		SSHPassword: b.Options.SSHPassword,
-		Env:         boshEnv,
-		Command:     boshCmd,
-	})
-}
-
-func (b Bosh) getProductId() (string, error) {
-	input := api.RequestServiceInvokeInput{
-		Path:   "/api/v0/deployed/products/",
-		Method: "GET",
 	}
 
-	output, err := b.requestService.Invoke(input)
+	input, err := b.SSHOptions.input(b.host)
 	if err != nil {
-		return "", fmt.Errorf("failed to get deployed product: %s", err)
+		return err
 	}
 
-	body, err := ioutil.ReadAll(output.Body)
+	creds, err := getDirectorCredentials(b.requestService)
 	if err != nil {
-		return "", fmt.Errorf("failed to read api response body: %s", err)
+		return err
 	}
 
-	var products []Products
-	if err = json.Unmarshal([]byte(body), &products); err != nil {
-		return "", fmt.Errorf("Could not unmarshal deployed products: %s", err)
+	var product *Products
+	if !selector.empty() {
+		p, err := b.getProduct(selector)
+		if err != nil {
+			return err
+		}
+		product = &p
 	}
 
-	for _, p := range products {
-		if p.Type == b.Options.ProductName {
-			return p.Guid, nil
+	cli := b.Options.BoshCLI
+	if cli == boshCLIAuto {
+		cli, err = b.detectBoshCLI()
+		if err != nil {
+			return err
 		}
 	}
 
-	return "", fmt.Errorf("Could not find product: %s", b.Options.ProductName)
-}
+	boshCmd, boshEnv := boshInvocation(cli, creds, product, b.Options.Interactive)
+	boshCmd = append(boshCmd, boshArgs...)
 
-func (b Bosh) getDirectorManifest() (DirectorManifest, error) {
-	var manifest DirectorManifest
-	input := api.RequestServiceInvokeInput{
-		Path:   "/api/v0/deployed/director/manifest/",
-		Method: "GET",
-	}
+	input.Interactive = b.Options.Interactive
+	input.InterruptGracePeriod = b.Options.GracePeriod
+	input.Env = boshEnv
+	input.Command = boshCmd
+	input.RawCommand = b.Options.Command
 
-	output, err := b.requestService.Invoke(input)
-	if err != nil {
-		return manifest, fmt.Errorf("failed to get director manifest: %s", err)
+	if batch != nil {
+		return b.executeBatch(input, batch)
 	}
 
-	body, err := ioutil.ReadAll(output.Body)
-	if err != nil {
-		return manifest, fmt.Errorf("failed to read api response body: %s", err)
-	}
+	return b.ssh.ExecuteOnRemote(input)
+}
 
-	if err = json.Unmarshal([]byte(body), &manifest); err != nil {
-		return manifest, fmt.Errorf("Could not unmarshal director manifest: %s", err)
+func (b Bosh) getProduct(selector productSelector) (Products, error) {
+	var products []Products
+	if err := getJSON(b.requestService, "/api/v0/deployed/products/", &products); err != nil {
+		return Products{}, err
 	}
 
-	return manifest, nil
+	return selectProduct(products, selector)
+}
+
+func getDirectorManifest(rs requestService) (DirectorManifest, error) {
+	var manifest DirectorManifest
+	err := getJSON(rs, "/api/v0/deployed/director/manifest/", &manifest)
+
+	return manifest, err
 }
@@ -0,0 +1,116 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The generations of the bosh CLI. Ops Manager 2.0 and later ship only the
+// Go v2 CLI; earlier versions run the Ruby v1 CLI through bundler.
+const (
+	boshCLIAuto = "auto"
+	boshCLIv1   = "v1"
+	boshCLIv2   = "v2"
+)
+
+// firstBoshCLIv2Version is the first major Ops Manager version without the
+// v1 CLI.
+const firstBoshCLIv2Version = 2
+
+type opsManagerInfo struct {
+	Info struct {
+		Version string `json:"version"`
+	} `json:"info"`
+}
+
+// boshInvocation returns the bosh command line, without the bosh arguments,
+// and its environment for the given CLI generation. The v1 CLI selects a
+// deployment by the path of its manifest on the Ops Manager VM and the v2 CLI
+// by its name.
+func boshInvocation(cli string, creds DirectorCredentials, product *Products, interactive bool) ([]string, []string) {
+	env := []string{
+		fmt.Sprintf("BOSH_CLIENT=%s", creds.Client),
+		fmt.Sprintf("BOSH_CLIENT_SECRET=%s", creds.ClientSecret),
+	}
+
+	var cmd []string
+	if cli == boshCLIv2 {
+		cmd = []string{"bosh"}
+	} else {
+		cmd = []string{"bundle", "exec", "bosh"}
+		env = append(env, "BUNDLE_GEMFILE=/home/tempest-web/tempest/web/vendor/bosh/Gemfile")
+	}
+
+	if !interactive {
+		cmd = append(cmd, "-n")
+	}
+
+	if cli == boshCLIv2 {
+		cmd = append(cmd, "--ca-cert", creds.CACertPath, "-e", creds.Address)
+		if product != nil && product.deploymentName() != "" {
+			cmd = append(cmd, "-d", product.deploymentName())
+		}
+	} else {
+		cmd = append(cmd, "--ca-cert", creds.CACertPath, "-t", creds.Address)
+		if product != nil && product.deploymentName() != "" {
+			cmd = append(cmd, "-d", fmt.Sprintf("/var/tempest/workspaces/default/deployments/%s.yml", product.Guid))
+		}
+	}
+
+	return cmd, env
+}
+
+// detectBoshCLI picks the CLI generation from the Ops Manager version. Ops
+// Managers too old to report their version run the v1 CLI.
+func (b Bosh) detectBoshCLI() (string, error) {
+	var info opsManagerInfo
+	err := getJSON(b.requestService, "/api/v0/info", &info)
+	if _, ok := err.(NotFoundError); ok {
+		return boshCLIv1, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	major, err := majorVersion(info.Info.Version)
+	if err != nil {
+		return "", err
+	}
+	if major >= firstBoshCLIv2Version {
+		return boshCLIv2, nil
+	}
+
+	return boshCLIv1, nil
+}
+
+// majorVersion parses the major version from Ops Manager versions such as
+// "2.1-build.212" or "1.12.3.0".
+func majorVersion(version string) (int, error) {
+	major := version
+	if i := strings.IndexAny(version, ".-"); i != -1 {
+		major = version[:i]
+	}
+
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse the Ops Manager version %q", version)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,158 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/om/commands"
+	"github.com/pivotal-cf/om/flags"
+)
+
+// go:generate counterfeiter -o ./fakes/security_service.go --fake-name SecurityService . securityService
+type securityService interface {
+	FetchRootCACert() (string, error)
+}
+
+type BoshEnv struct {
+	requestService  requestService
+	securityService securityService
+	stdout          logger
+	Options         struct {
+		Format     string `short:"f" long:"format" description:"output format: export, fish, envrc or json" default:"export"`
+		CACertPath string `long:"ca-cert-path" description:"file to write the director's CA certificate to (defaults to a new temporary file)"`
+	}
+}
+
+// boshEnvironment is what the bosh CLI needs to talk to the director, as
+// environment variables in the order they are printed.
+type boshEnvironment struct {
+	Environment  string `json:"BOSH_ENVIRONMENT"`
+	Client       string `json:"BOSH_CLIENT"`
+	ClientSecret string `json:"BOSH_CLIENT_SECRET"`
+	CACert       string `json:"BOSH_CA_CERT"`
+}
+
+func (e boshEnvironment) vars() [][2]string {
+	return [][2]string{
+		{"BOSH_ENVIRONMENT", e.Environment},
+		{"BOSH_CLIENT", e.Client},
+		{"BOSH_CLIENT_SECRET", e.ClientSecret},
+		{"BOSH_CA_CERT", e.CACert},
+	}
+}
+
+func NewBoshEnvCommand(rs requestService, ss securityService, stdout logger) BoshEnv {
+	return BoshEnv{requestService: rs, securityService: ss, stdout: stdout}
+}
+
+func (b BoshEnv) Usage() commands.Usage {
+	return commands.Usage{
+		Description:      "Prints the environment for running a local bosh CLI against the director, with its CA certificate written to a file",
+		ShortDescription: "Prints the environment for a local bosh CLI",
+		Flags:            b.Options,
+	}
+}
+
+func (b BoshEnv) Execute(args []string) error {
+	_, err := flags.Parse(&b.Options, args)
+	if err != nil {
+		return fmt.Errorf("could not parse bosh-env flags: %s", err)
+	}
+
+	switch b.Options.Format {
+	case "export", "envrc", "fish", "json":
+	default:
+		return fmt.Errorf("unknown format %q: expected export, fish, envrc or json", b.Options.Format)
+	}
+
+	creds, err := getDirectorCredentials(b.requestService)
+	if err != nil {
+		return err
+	}
+
+	ca, err := b.securityService.FetchRootCACert()
+	if err != nil {
+		return fmt.Errorf("could not fetch the root CA certificate: %s", err)
+	}
+
+	caPath, err := b.writeCACert(ca)
+	if err != nil {
+		return err
+	}
+
+	env := boshEnvironment{
+		Environment:  creds.Address,
+		Client:       creds.Client,
+		ClientSecret: creds.ClientSecret,
+		CACert:       caPath,
+	}
+
+	switch b.Options.Format {
+	case "json":
+		output, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.stdout.Printf("%s\n", output)
+	case "fish":
+		for _, v := range env.vars() {
+			b.stdout.Printf("set -gx %s %s;\n", v[0], fishQuote(v[1]))
+		}
+	default:
+		// direnv runs .envrc files with bash, so they are the same exports.
+		for _, v := range env.vars() {
+			b.stdout.Printf("export %s=%s\n", v[0], shellQuote(v[1]))
+		}
+	}
+
+	return nil
+}
+
+// writeCACert writes the CA certificate to the --ca-cert-path file, or to a
+// new temporary file, and returns its absolute path.
+func (b BoshEnv) writeCACert(ca string) (string, error) {
+	path := b.Options.CACertPath
+	if path == "" {
+		file, err := ioutil.TempFile("", "bosh-ca-cert-")
+		if err != nil {
+			return "", fmt.Errorf("could not create a file for the CA certificate: %s", err)
+		}
+		file.Close()
+		path = file.Name()
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(ca), 0644); err != nil {
+		return "", fmt.Errorf("could not write the CA certificate to %s: %s", path, err)
+	}
+
+	return path, nil
+}
+
+// fishQuote quotes s for fish, where backslashes and single quotes are
+// escaped inside single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,150 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	"github.com/pivotal-cf/execute-on-opsman/commands/fakes"
+	"github.com/pivotal-cf/om/api"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BoshEnv", func() {
+	Describe("Execute", func() {
+		var (
+			command         commands.BoshEnv
+			requestService  *omfakes.RequestService
+			securityService *fakes.SecurityService
+			stdout          *omfakes.Logger
+			tmpDir          string
+			caPath          string
+		)
+
+		BeforeEach(func() {
+			requestService = &omfakes.RequestService{}
+			requestService.InvokeReturns(api.RequestServiceInvokeOutput{
+				StatusCode: http.StatusOK,
+				Body:       strings.NewReader(`{"credential": "BOSH_CLIENT=ops_manager BOSH_CLIENT_SECRET=opsman's_secret BOSH_CA_CERT=/var/tempest/workspaces/default/root_ca_certificate BOSH_ENVIRONMENT=10.0.4.2 bosh "}`),
+			}, nil)
+
+			securityService = &fakes.SecurityService{}
+			securityService.FetchRootCACertReturns("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", nil)
+			stdout = &omfakes.Logger{}
+
+			var err error
+			tmpDir, err = ioutil.TempDir("", "bosh-env")
+			Expect(err).ToNot(HaveOccurred())
+			caPath = filepath.Join(tmpDir, "root_ca.pem")
+
+			command = commands.NewBoshEnvCommand(requestService, securityService, stdout)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpDir)
+		})
+
+		output := func() string {
+			var lines []string
+			for i := 0; i < stdout.PrintfCallCount(); i++ {
+				format, v := stdout.PrintfArgsForCall(i)
+				lines = append(lines, fmt.Sprintf(format, v...))
+			}
+			return strings.Join(lines, "")
+		}
+
+		It("writes the CA certificate and prints exports for it", func() {
+			Expect(command.Execute([]string{"--ca-cert-path", caPath})).To(Succeed())
+
+			contents, err := ioutil.ReadFile(caPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(HavePrefix("-----BEGIN CERTIFICATE-----"))
+
+			Expect(output()).To(Equal(`export BOSH_ENVIRONMENT=10.0.4.2
+export BOSH_CLIENT=ops_manager
+export BOSH_CLIENT_SECRET='opsman'\''s_secret'
+export BOSH_CA_CERT=` + caPath + "\n"))
+
+			Expect(requestService.InvokeArgsForCall(0).Path).To(Equal("/api/v0/deployed/director/credentials/bosh_commandline_credentials"))
+		})
+
+		It("prints fish commands", func() {
+			Expect(command.Execute([]string{"--ca-cert-path", caPath, "--format", "fish"})).To(Succeed())
+
+			Expect(output()).To(Equal(`set -gx BOSH_ENVIRONMENT '10.0.4.2';
+set -gx BOSH_CLIENT 'ops_manager';
+set -gx BOSH_CLIENT_SECRET 'opsman\'s_secret';
+set -gx BOSH_CA_CERT '` + caPath + "';\n"))
+		})
+
+		It("prints an .envrc for direnv", func() {
+			Expect(command.Execute([]string{"--ca-cert-path", caPath, "--format", "envrc"})).To(Succeed())
+
+			Expect(output()).To(ContainSubstring("export BOSH_CA_CERT=" + caPath + "\n"))
+		})
+
+		It("prints json", func() {
+			Expect(command.Execute([]string{"--ca-cert-path", caPath, "--format", "json"})).To(Succeed())
+
+			var env map[string]string
+			Expect(json.Unmarshal([]byte(output()), &env)).To(Succeed())
+			Expect(env).To(Equal(map[string]string{
+				"BOSH_ENVIRONMENT":   "10.0.4.2",
+				"BOSH_CLIENT":        "ops_manager",
+				"BOSH_CLIENT_SECRET": "opsman's_secret",
+				"BOSH_CA_CERT":       caPath,
+			}))
+		})
+
+		It("writes the CA certificate to a temporary file by default", func() {
+			Expect(command.Execute([]string{"--format", "json"})).To(Succeed())
+
+			var env map[string]string
+			Expect(json.Unmarshal([]byte(output()), &env)).To(Succeed())
+			defer os.Remove(env["BOSH_CA_CERT"])
+
+			Expect(filepath.IsAbs(env["BOSH_CA_CERT"])).To(BeTrue())
+			contents, err := ioutil.ReadFile(env["BOSH_CA_CERT"])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(HavePrefix("-----BEGIN CERTIFICATE-----"))
+		})
+
+		It("rejects unknown formats", func() {
+			err := command.Execute([]string{"--format", "yaml"})
+			Expect(err).To(MatchError(`unknown format "yaml": expected export, fish, envrc or json`))
+			Expect(requestService.InvokeCallCount()).To(Equal(0))
+		})
+
+		It("returns an error when the CA certificate cannot be fetched", func() {
+			securityService.FetchRootCACertReturns("", errors.New("unauthorized"))
+
+			err := command.Execute([]string{"--ca-cert-path", caPath})
+			Expect(err).To(MatchError("could not fetch the root CA certificate: unauthorized"))
+			Expect(stdout.PrintfCallCount()).To(Equal(0))
+		})
+	})
+})
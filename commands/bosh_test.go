@@ -17,8 +17,11 @@ package commands_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/pivotal-cf/execute-on-opsman/commands"
 	"github.com/pivotal-cf/execute-on-opsman/commands/fakes"
@@ -37,9 +40,11 @@ var _ = Describe("Bosh", func() {
 			sshClient      *fakes.SSHClient
 			stdout         *omfakes.Logger
 			stderr         *omfakes.Logger
+			version        string
 		)
 
 		BeforeEach(func() {
+			version = "1.12.3.0"
 			requestService = &omfakes.RequestService{}
 			stdout = &omfakes.Logger{}
 			stderr = &omfakes.Logger{}
@@ -89,6 +94,18 @@ var _ = Describe("Bosh", func() {
 							}]
 						}`),
 					}, nil
+				} else if input.Path == "/api/v0/deployed/director/credentials/bosh_commandline_credentials" {
+					return api.RequestServiceInvokeOutput{
+						StatusCode: http.StatusOK,
+						Body: strings.NewReader(`{
+							"credential": "BOSH_CLIENT=ops_manager BOSH_CLIENT_SECRET=opsman_secret BOSH_CA_CERT=/var/tempest/workspaces/default/root_ca_certificate BOSH_ENVIRONMENT=10.0.4.2 bosh "
+						}`),
+					}, nil
+				} else if input.Path == "/api/v0/info" {
+					return api.RequestServiceInvokeOutput{
+						StatusCode: http.StatusOK,
+						Body:       strings.NewReader(fmt.Sprintf(`{"info": {"version": %q}}`, version)),
+					}, nil
 				}
 				return api.RequestServiceInvokeOutput{}, fmt.Errorf("not supported")
 			}
@@ -104,7 +121,7 @@ var _ = Describe("Bosh", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			input := requestService.InvokeArgsForCall(0)
-			Expect(input.Path).To(Equal("/api/v0/deployed/director/manifest/"))
+			Expect(input.Path).To(Equal("/api/v0/deployed/director/credentials/bosh_commandline_credentials"))
 			Expect(input.Method).To(Equal("GET"))
 
 			input = requestService.InvokeArgsForCall(1)
@@ -115,16 +132,18 @@ var _ = Describe("Bosh", func() {
 			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
 			Expect(sshInput.SSHKeyPath).To(Equal("/path/to/key.pem"))
 			Expect(sshInput.Host).To(Equal("pcf.example.com"))
-			Expect(sshInput.Env).To(ContainElement(`BOSH_CLIENT="ops_manager"`))
-			Expect(sshInput.Env).To(ContainElement(`BOSH_CLIENT_SECRET="opsman_secret"`))
+			Expect(sshInput.Env).To(ContainElement("BOSH_CLIENT=ops_manager"))
+			Expect(sshInput.Env).To(ContainElement("BOSH_CLIENT_SECRET=opsman_secret"))
 			Expect(sshInput.Env).To(ContainElement(`BUNDLE_GEMFILE=/home/tempest-web/tempest/web/vendor/bosh/Gemfile`))
 
-			Expect(sshInput.Command).To(ContainElement(`bundle exec bosh`))
-			Expect(sshInput.Command).To(ContainElement(`-n`))
-			Expect(sshInput.Command).To(ContainElement(`--ca-cert /var/tempest/workspaces/default/root_ca_certificate`))
-			Expect(sshInput.Command).To(ContainElement(`-t 10.0.4.2`))
-			Expect(sshInput.Command).To(ContainElement(`-d /var/tempest/workspaces/default/deployments/cf-guid.yml`))
-			Expect(sshInput.Command).To(ContainElement(`stop`))
+			Expect(sshInput.Command).To(Equal([]string{
+				"bundle", "exec", "bosh", "-n",
+				"--ca-cert", "/var/tempest/workspaces/default/root_ca_certificate",
+				"-t", "10.0.4.2",
+				"-d", "/var/tempest/workspaces/default/deployments/cf-guid.yml",
+			}))
+			Expect(sshInput.RawCommand).To(Equal("stop"))
+			Expect(strings.Join(sshInput.Command, " ")).ToNot(ContainSubstring("opsman_secret"))
 		})
 
 		It("executes the bosh command", func() {
@@ -136,7 +155,7 @@ var _ = Describe("Bosh", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			input := requestService.InvokeArgsForCall(0)
-			Expect(input.Path).To(Equal("/api/v0/deployed/director/manifest/"))
+			Expect(input.Path).To(Equal("/api/v0/deployed/director/credentials/bosh_commandline_credentials"))
 			Expect(input.Method).To(Equal("GET"))
 
 			input = requestService.InvokeArgsForCall(1)
@@ -146,17 +165,342 @@ var _ = Describe("Bosh", func() {
 			Expect(sshClient.ExecuteOnRemoteCallCount()).To(Equal(1))
 			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
 			Expect(sshInput.SSHPassword).To(Equal("fancy-password"))
+			Expect(sshInput.AuthMethods).To(BeEmpty())
 			Expect(sshInput.Host).To(Equal("pcf.example.com"))
-			Expect(sshInput.Env).To(ContainElement(`BOSH_CLIENT="ops_manager"`))
-			Expect(sshInput.Env).To(ContainElement(`BOSH_CLIENT_SECRET="opsman_secret"`))
+			Expect(sshInput.Env).To(ContainElement("BOSH_CLIENT=ops_manager"))
+			Expect(sshInput.Env).To(ContainElement("BOSH_CLIENT_SECRET=opsman_secret"))
 			Expect(sshInput.Env).To(ContainElement(`BUNDLE_GEMFILE=/home/tempest-web/tempest/web/vendor/bosh/Gemfile`))
 
-			Expect(sshInput.Command).To(ContainElement(`bundle exec bosh`))
-			Expect(sshInput.Command).To(ContainElement(`-n`))
-			Expect(sshInput.Command).To(ContainElement(`--ca-cert /var/tempest/workspaces/default/root_ca_certificate`))
-			Expect(sshInput.Command).To(ContainElement(`-t 10.0.4.2`))
-			Expect(sshInput.Command).To(ContainElement(`-d /var/tempest/workspaces/default/deployments/cf-guid.yml`))
-			Expect(sshInput.Command).To(ContainElement(`stop`))
+			Expect(sshInput.Command).To(Equal([]string{
+				"bundle", "exec", "bosh", "-n",
+				"--ca-cert", "/var/tempest/workspaces/default/root_ca_certificate",
+				"-t", "10.0.4.2",
+				"-d", "/var/tempest/workspaces/default/deployments/cf-guid.yml",
+			}))
+			Expect(sshInput.RawCommand).To(Equal("stop"))
+		})
+
+		It("passes host key verification options to the ssh client", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--known-hosts", "/path/to/known_hosts",
+				"--trust-on-first-use",
+				"--ssh-host-key-fingerprint", "SHA256:abc",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.KnownHostsPath).To(Equal("/path/to/known_hosts"))
+			Expect(sshInput.TrustOnFirstUse).To(BeTrue())
+			Expect(sshInput.HostKeyFingerprint).To(Equal("SHA256:abc"))
+		})
+
+		It("passes jump hosts to the ssh client", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--jump-host", "ubuntu@bastion.example.com:2222?auth=agent",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.JumpHosts).To(Equal([]commands.JumpHost{
+				{User: "ubuntu", Host: "bastion.example.com", Port: 2222, AuthMethods: []string{"agent"}},
+			}))
+		})
+
+		It("passes every jump host when the flag is repeated", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--jump-host", "ubuntu@bastion.example.com:2222?auth=agent",
+				"--jump-host=10.0.0.4",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.JumpHosts).To(Equal([]commands.JumpHost{
+				{User: "ubuntu", Host: "bastion.example.com", Port: 2222, AuthMethods: []string{"agent"}},
+				{Host: "10.0.0.4", Port: 22},
+			}))
+		})
+
+		It("runs bosh interactively when asked to", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"-t",
+				"--command", "ssh",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.Interactive).To(BeTrue())
+			Expect(sshInput.Command).ToNot(ContainElement("-n"))
+			Expect(sshInput.RawCommand).To(Equal("ssh"))
+		})
+
+		It("passes the bosh arguments after -- without interpreting them", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--product-name", "cf",
+				"--",
+				"ssh", "diego_cell/0", "-c", "sudo monit summary",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.Command).To(HaveLen(14))
+			Expect(sshInput.Command[10:]).To(Equal([]string{"ssh", "diego_cell/0", "-c", "sudo monit summary"}))
+			Expect(sshInput.RawCommand).To(BeEmpty())
+		})
+
+		It("passes the interrupt grace period to the ssh client", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--interrupt-grace-period", "30s",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.InterruptGracePeriod).To(Equal(30 * time.Second))
+		})
+
+		It("passes the connection timeout and retry settings to the ssh client", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--ssh-connect-timeout", "5s",
+				"--ssh-retries", "5",
+				"--ssh-retry-on", "connection refused, no route to host",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.ConnectTimeout).To(Equal(5 * time.Second))
+			Expect(sshInput.Retries).To(Equal(5))
+			Expect(sshInput.RetryableErrors).To(Equal([]string{"connection refused", "no route to host"}))
+		})
+
+		It("passes the keepalive settings to the ssh client", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--ssh-keepalive-interval", "15s",
+				"--ssh-keepalive-timeout", "1m",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.KeepaliveInterval).To(Equal(15 * time.Second))
+			Expect(sshInput.KeepaliveTimeout).To(Equal(time.Minute))
+		})
+
+		It("disables keepalives when the interval is zero", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--ssh-keepalive-interval", "0s",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.KeepaliveInterval).To(BeNumerically("<", 0))
+		})
+
+		It("returns the ssh client error unchanged", func() {
+			sshClient.ExecuteOnRemoteReturns(commands.RemoteExitError{ExitStatus: 2})
+
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--command", "stop",
+			})
+			Expect(err).To(Equal(commands.RemoteExitError{ExitStatus: 2}))
+		})
+
+		Context("when a commands file is given", func() {
+			var (
+				connection   *fakes.SSHConnection
+				commandsFile string
+			)
+
+			BeforeEach(func() {
+				connection = &fakes.SSHConnection{}
+				sshClient.ConnectReturns(connection, nil)
+
+				f, err := ioutil.TempFile("", "commands")
+				Expect(err).ToNot(HaveOccurred())
+				defer f.Close()
+
+				_, err = f.WriteString("# maintenance\ndeployments\n\nrecreate\nvms --vitals\n")
+				Expect(err).ToNot(HaveOccurred())
+				commandsFile = f.Name()
+			})
+
+			AfterEach(func() {
+				os.Remove(commandsFile)
+			})
+
+			It("runs each command over a single connection", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(requestService.InvokeCallCount()).To(Equal(2))
+				Expect(sshClient.ConnectCallCount()).To(Equal(1))
+				Expect(sshClient.ConnectArgsForCall(0).SSHKeyPath).To(Equal("/path/to/key.pem"))
+				Expect(sshClient.ExecuteOnRemoteCallCount()).To(Equal(0))
+
+				Expect(connection.ExecuteOnRemoteCallCount()).To(Equal(3))
+				Expect(connection.ExecuteOnRemoteArgsForCall(0).RawCommand).To(Equal("deployments"))
+				Expect(connection.ExecuteOnRemoteArgsForCall(1).RawCommand).To(Equal("recreate"))
+				Expect(connection.ExecuteOnRemoteArgsForCall(2).RawCommand).To(Equal("vms --vitals"))
+				Expect(connection.ExecuteOnRemoteArgsForCall(2).Env).To(ContainElement("BOSH_CLIENT_SECRET=opsman_secret"))
+				Expect(connection.CloseCallCount()).To(Equal(1))
+			})
+
+			It("stops at the first failure", func() {
+				connection.ExecuteOnRemoteStub = func(input commands.ExecuteOnRemoteInput) error {
+					if input.RawCommand == "recreate" {
+						return commands.RemoteExitError{ExitStatus: 1}
+					}
+					return nil
+				}
+
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+				})
+				Expect(err).To(Equal(commands.BatchError{Failed: 1, Total: 3, Err: commands.RemoteExitError{ExitStatus: 1}}))
+				Expect(connection.ExecuteOnRemoteCallCount()).To(Equal(2))
+
+				var logged []string
+				for i := 0; i < stderr.PrintfCallCount(); i++ {
+					format, args := stderr.PrintfArgsForCall(i)
+					logged = append(logged, fmt.Sprintf(format, args...))
+				}
+				Expect(logged).To(ContainElement("  ok       bosh deployments\n"))
+				Expect(logged).To(ContainElement("  failed   bosh recreate\n"))
+				Expect(logged).To(ContainElement("  skipped  bosh vms --vitals\n"))
+			})
+
+			It("runs the remaining commands when asked to continue on failure", func() {
+				connection.ExecuteOnRemoteReturns(commands.RemoteExitError{ExitStatus: 1})
+
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+					"--continue-on-failure",
+				})
+				Expect(err).To(Equal(commands.BatchError{Failed: 3, Total: 3, Err: commands.RemoteExitError{ExitStatus: 1}}))
+				Expect(connection.ExecuteOnRemoteCallCount()).To(Equal(3))
+			})
+
+			It("stops when interrupted, even when asked to continue on failure", func() {
+				connection.ExecuteOnRemoteReturns(commands.InterruptedError{Signal: "INT"})
+
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+					"--continue-on-failure",
+				})
+				Expect(err).To(BeAssignableToTypeOf(commands.BatchError{}))
+				Expect(connection.ExecuteOnRemoteCallCount()).To(Equal(1))
+			})
+
+			It("fails when a bosh command is also given", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+					"--command", "stop",
+				})
+				Expect(err).To(MatchError("bosh commands may not be given along with --commands-file"))
+			})
+
+			It("fails when interactive mode is asked for", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+					"--interactive",
+				})
+				Expect(err).To(MatchError("--interactive may not be given along with --commands-file"))
+				Expect(sshClient.ConnectCallCount()).To(Equal(0))
+			})
+
+			It("returns the connection error", func() {
+				sshClient.ConnectReturns(nil, commands.DialError{Address: "pcf.example.com:22"})
+
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--commands-file", commandsFile,
+				})
+				Expect(err).To(Equal(commands.DialError{Address: "pcf.example.com:22"}))
+				Expect(connection.ExecuteOnRemoteCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when an ssh key passphrase is provided", func() {
+			It("reads the passphrase from a file", func() {
+				passphraseFile, err := ioutil.TempFile("", "passphrase")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.Remove(passphraseFile.Name())
+
+				_, err = passphraseFile.WriteString("correct horse\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(passphraseFile.Close()).To(Succeed())
+
+				err = command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--ssh-key-passphrase-file", passphraseFile.Name(),
+					"--command", "stop",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+				Expect(sshInput.SSHKeyPassphrase).To(Equal("correct horse"))
+			})
+
+			It("fails when both a passphrase and a passphrase file are provided", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--ssh-key-passphrase", "correct horse",
+					"--ssh-key-passphrase-file", "/path/to/passphrase",
+					"--command", "stop",
+				})
+				Expect(err).To(MatchError("only one of ssh key passphrase or ssh key passphrase file may be provided"))
+			})
+		})
+
+		It("defaults to the ubuntu user on port 22 of the target host", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.Host).To(Equal("pcf.example.com"))
+			Expect(sshInput.Port).To(Equal(22))
+			Expect(sshInput.User).To(Equal("ubuntu"))
+		})
+
+		It("connects to the given ssh host, port and user", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--ssh-host", "10.0.0.5",
+				"--ssh-port", "2222",
+				"--ssh-user", "tempest",
+				"--command", "stop",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+			Expect(sshInput.Host).To(Equal("10.0.0.5"))
+			Expect(sshInput.Port).To(Equal(2222))
+			Expect(sshInput.User).To(Equal("tempest"))
 		})
 
 		Context("when no product name is specified", func() {
@@ -167,20 +511,386 @@ var _ = Describe("Bosh", func() {
 				})
 				Ω(err).ToNot(HaveOccurred())
 
-				Expect(requestService.InvokeCallCount()).To(Equal(1))
+				Expect(requestService.InvokeCallCount()).To(Equal(2))
 				input := requestService.InvokeArgsForCall(0)
-				Expect(input.Path).To(Equal("/api/v0/deployed/director/manifest/"))
+				Expect(input.Path).To(Equal("/api/v0/deployed/director/credentials/bosh_commandline_credentials"))
 				Expect(input.Method).To(Equal("GET"))
+				Expect(requestService.InvokeArgsForCall(1).Path).To(Equal("/api/v0/info"))
+			})
+		})
+
+		Context("when a product type is deployed more than once", func() {
+			BeforeEach(func() {
+				stub := requestService.InvokeStub
+				requestService.InvokeStub = func(input api.RequestServiceInvokeInput) (api.RequestServiceInvokeOutput, error) {
+					if input.Path == "/api/v0/deployed/products/" {
+						return api.RequestServiceInvokeOutput{
+							StatusCode: http.StatusOK,
+							Body: strings.NewReader(`[
+								{"installation_name": "p-bosh-guid", "guid": "p-bosh-guid", "type": "p-bosh"},
+								{"installation_name": "p-isolation-segment-abc", "guid": "p-isolation-segment-abc-guid", "type": "p-isolation-segment"},
+								{"installation_name": "p-isolation-segment-def", "guid": "p-isolation-segment-def-guid", "type": "p-isolation-segment"},
+								{"installation_name": "pivotal-mysql-123", "guid": "pivotal-mysql-123-guid", "type": "pivotal-mysql"}
+							]`),
+						}, nil
+					}
+					return stub(input)
+				}
+			})
+
+			It("lists the candidates when the product type is ambiguous", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-name", "p-isolation-segment",
+					"--command", "vms",
+				})
+				Expect(err).To(BeAssignableToTypeOf(commands.AmbiguousProductError{}))
+				Expect(err).To(MatchError("2 deployed products are of type p-isolation-segment; choose one with --installation-name or --product-guid: " +
+					"p-isolation-segment-abc (guid p-isolation-segment-abc-guid), p-isolation-segment-def (guid p-isolation-segment-def-guid)"))
+				Expect(sshClient.ExecuteOnRemoteCallCount()).To(Equal(0))
+			})
+
+			It("selects the product by installation name", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--installation-name", "p-isolation-segment-def",
+					"--command", "vms",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sshClient.ExecuteOnRemoteArgsForCall(0).Command).To(ContainElement(
+					"/var/tempest/workspaces/default/deployments/p-isolation-segment-def-guid.yml",
+				))
+			})
+
+			It("selects the product by GUID", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-guid", "p-isolation-segment-abc-guid",
+					"--bosh-cli", "v2",
+					"--command", "vms",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sshClient.ExecuteOnRemoteArgsForCall(0).Command).To(ContainElement("p-isolation-segment-abc-guid"))
+			})
+
+			It("suggests the closest products when none matches", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-name", "pivotal-mysl",
+					"--command", "vms",
+				})
+				Expect(err).To(MatchError("Could not find product: pivotal-mysl; did you mean --product-name pivotal-mysql?"))
+			})
+
+			It("suggests installation names that contain the one given", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--installation-name", "isolation-segment",
+					"--command", "vms",
+				})
+				Expect(err).To(Equal(commands.ProductNotFoundError{
+					Flag:        "--installation-name",
+					Value:       "isolation-segment",
+					Suggestions: []string{"p-isolation-segment-abc", "p-isolation-segment-def"},
+				}))
+			})
+
+			It("fails when more than one selector is given", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-name", "p-isolation-segment",
+					"--product-guid", "p-isolation-segment-abc-guid",
+					"--command", "vms",
+				})
+				Expect(err).To(MatchError("only one of --product-name, --installation-name or --product-guid may be provided"))
+				Expect(requestService.InvokeCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the Ops Manager API returns an error", func() {
+			var (
+				status int
+				body   string
+				fails  int
+			)
+
+			BeforeEach(func() {
+				fails = 1
+				stub := requestService.InvokeStub
+				requestService.InvokeStub = func(input api.RequestServiceInvokeInput) (api.RequestServiceInvokeOutput, error) {
+					if input.Path == "/api/v0/deployed/products/" && fails > 0 {
+						fails--
+						return api.RequestServiceInvokeOutput{StatusCode: status, Body: strings.NewReader(body)}, nil
+					}
+					return stub(input)
+				}
+			})
+
+			execute := func() error {
+				return command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-name", "cf",
+					"--command", "vms",
+				})
+			}
+
+			It("returns an unauthorized error with Ops Manager's message", func() {
+				status, body = http.StatusUnauthorized, `{"errors": ["Bad credentials"]}`
+
+				err := execute()
+				Expect(err).To(BeAssignableToTypeOf(commands.UnauthorizedError{}))
+				Expect(err).To(MatchError("GET /api/v0/deployed/products/ failed with status 401 Unauthorized: Bad credentials"))
+				Expect(sshClient.ExecuteOnRemoteCallCount()).To(Equal(0))
+			})
+
+			It("returns a forbidden error without retrying", func() {
+				status, body = http.StatusForbidden, `{"errors": {"base": ["not allowed"], "user": ["is restricted"]}}`
+
+				err := execute()
+				Expect(err).To(BeAssignableToTypeOf(commands.ForbiddenError{}))
+				Expect(err).To(MatchError(HaveSuffix(": not allowed; user is restricted")))
+				Expect(requestService.InvokeCallCount()).To(Equal(2))
+			})
+
+			It("does not retry client errors whose message looks like a dropped connection", func() {
+				status, body = http.StatusUnauthorized, `{"errors": ["token validation failed: unexpected EOF"]}`
+
+				err := execute()
+				Expect(err).To(BeAssignableToTypeOf(commands.UnauthorizedError{}))
+				Expect(requestService.InvokeCallCount()).To(Equal(2))
+
+				fails = 1
+				status, body = http.StatusUnprocessableEntity, `{"errors": ["connection reset by peer"]}`
+
+				err = execute()
+				Expect(err).To(BeAssignableToTypeOf(commands.APIError{}))
+				Expect(requestService.InvokeCallCount()).To(Equal(4))
+			})
+
+			It("does not include HTML error pages in the error", func() {
+				status, body = http.StatusNotFound, "<html><body>Not Found</body></html>"
+
+				err := execute()
+				Expect(err).To(Equal(commands.NotFoundError{APIError: commands.APIError{
+					Method:     "GET",
+					Path:       "/api/v0/deployed/products/",
+					StatusCode: http.StatusNotFound,
+				}}))
+			})
+
+			It("retries server errors", func() {
+				status, body = http.StatusBadGateway, "<html>502 Bad Gateway</html>"
+
+				Expect(execute()).To(Succeed())
+				Expect(requestService.InvokeCallCount()).To(Equal(4))
+				Expect(sshClient.ExecuteOnRemoteCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the Ops Manager has no credentials API", func() {
+			var manifest string
+
+			BeforeEach(func() {
+				manifest = `{"jobs": [{"properties": {
+					"uaa": {"clients": {"ops_manager": {"secret": "manifest_secret"}}},
+					"director": {"address": "10.0.4.3"}
+				}}]}`
+
+				requestService.InvokeStub = func(input api.RequestServiceInvokeInput) (api.RequestServiceInvokeOutput, error) {
+					switch input.Path {
+					case "/api/v0/deployed/director/manifest/":
+						return api.RequestServiceInvokeOutput{StatusCode: http.StatusOK, Body: strings.NewReader(manifest)}, nil
+					case "/api/v0/info":
+						return api.RequestServiceInvokeOutput{StatusCode: http.StatusOK, Body: strings.NewReader(`{"info": {"version": "1.10.8"}}`)}, nil
+					default:
+						return api.RequestServiceInvokeOutput{StatusCode: http.StatusNotFound, Body: strings.NewReader("")}, nil
+					}
+				}
+			})
+
+			It("reads the credentials from the director manifest", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--command", "vms",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(requestService.InvokeArgsForCall(1).Path).To(Equal("/api/v0/deployed/director/manifest/"))
+				sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+				Expect(sshInput.Env).To(ContainElement("BOSH_CLIENT_SECRET=manifest_secret"))
+				Expect(sshInput.Command).To(ContainElement("10.0.4.3"))
+			})
+
+			It("returns an error when the manifest has no jobs", func() {
+				manifest = `{"jobs": []}`
+
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--command", "vms",
+				})
+				Expect(err).To(MatchError(ContainSubstring("falling back to the director manifest: the director manifest has no jobs")))
+				Expect(sshClient.ExecuteOnRemoteCallCount()).To(Equal(0))
+			})
+
+			It("returns an error when the manifest has no client secret", func() {
+				manifest = `{"jobs": [{"properties": {"director": {"address": "10.0.4.3"}}}]}`
+
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--command", "vms",
+				})
+				Expect(err).To(MatchError(ContainSubstring("the director manifest has no client secret")))
+			})
+		})
+
+		Context("when the Ops Manager ships the v2 bosh CLI", func() {
+			BeforeEach(func() {
+				version = "2.1-build.212"
+			})
+
+			It("runs the v2 CLI against the deployment by name", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-name", "cf",
+					"--", "vms",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+				Expect(sshInput.Command).To(Equal([]string{
+					"bosh", "-n",
+					"--ca-cert", "/var/tempest/workspaces/default/root_ca_certificate",
+					"-e", "10.0.4.2",
+					"-d", "cf-guid",
+					"vms",
+				}))
+				Expect(sshInput.Env).To(ConsistOf("BOSH_CLIENT=ops_manager", "BOSH_CLIENT_SECRET=opsman_secret"))
+			})
+
+			It("runs the v2 CLI without a deployment for the director", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--product-name", "p-bosh",
+					"--", "tasks",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sshClient.ExecuteOnRemoteArgsForCall(0).Command).To(Equal([]string{
+					"bosh", "-n",
+					"--ca-cert", "/var/tempest/workspaces/default/root_ca_certificate",
+					"-e", "10.0.4.2",
+					"tasks",
+				}))
+			})
+
+			It("runs the v1 CLI when asked to", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--bosh-cli", "v1",
+					"--command", "vms",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sshClient.ExecuteOnRemoteArgsForCall(0).Command[:3]).To(Equal([]string{"bundle", "exec", "bosh"}))
+				Expect(requestService.InvokeCallCount()).To(Equal(1))
+			})
+		})
+
+		It("runs the v2 CLI when asked to, without looking up the Ops Manager version", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--bosh-cli", "v2",
+				"--interactive",
+				"--command", "ssh",
 			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(sshClient.ExecuteOnRemoteArgsForCall(0).Command).To(Equal([]string{
+				"bosh",
+				"--ca-cert", "/var/tempest/workspaces/default/root_ca_certificate",
+				"-e", "10.0.4.2",
+			}))
+			Expect(requestService.InvokeCallCount()).To(Equal(1))
+		})
+
+		It("runs the v1 CLI on Ops Managers that do not report their version", func() {
+			stub := requestService.InvokeStub
+			requestService.InvokeStub = func(input api.RequestServiceInvokeInput) (api.RequestServiceInvokeOutput, error) {
+				if input.Path == "/api/v0/info" {
+					return api.RequestServiceInvokeOutput{StatusCode: http.StatusNotFound, Body: strings.NewReader("")}, nil
+				}
+				return stub(input)
+			}
+
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--command", "vms",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sshClient.ExecuteOnRemoteArgsForCall(0).Command[:3]).To(Equal([]string{"bundle", "exec", "bosh"}))
+		})
+
+		It("rejects an unknown bosh CLI", func() {
+			err := command.Execute([]string{
+				"--ssh-key-path", "/path/to/key.pem",
+				"--bosh-cli", "v3",
+				"--command", "vms",
+			})
+			Expect(err).To(MatchError(`unknown bosh CLI "v3": expected v1, v2 or auto`))
+			Expect(requestService.InvokeCallCount()).To(Equal(0))
 		})
 
 		Context("Validation", func() {
+			var authSock string
+
+			BeforeEach(func() {
+				authSock = os.Getenv("SSH_AUTH_SOCK")
+				os.Unsetenv("SSH_AUTH_SOCK")
+			})
+
+			AfterEach(func() {
+				os.Setenv("SSH_AUTH_SOCK", authSock)
+			})
+
 			It("fails when no ssh is provided", func() {
 				err := command.Execute([]string{
 					"--command", "stop",
 				})
 				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(Equal("either ssh key path or the opsman ssh password must be provided"))
+				Expect(err.Error()).To(Equal("either ssh key path, the opsman ssh password or a running ssh-agent must be provided"))
+			})
+
+			It("accepts a running ssh-agent in place of a key or password", func() {
+				os.Setenv("SSH_AUTH_SOCK", "/tmp/agent.sock")
+
+				err := command.Execute([]string{
+					"--ssh-auth", "agent",
+					"--command", "stop",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				sshInput := sshClient.ExecuteOnRemoteArgsForCall(0)
+				Expect(sshInput.AuthMethods).To(Equal([]string{"agent"}))
+			})
+
+			It("fails on an unknown ssh auth method", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--ssh-auth", "agent,kerberos",
+					"--command", "stop",
+				})
+				Expect(err).To(MatchError(ContainSubstring(`unknown ssh auth method "kerberos"`)))
+			})
+
+			It("fails when the bosh command is given both ways", func() {
+				err := command.Execute([]string{
+					"--ssh-key-path", "/path/to/key.pem",
+					"--command", "stop",
+					"--", "start",
+				})
+				Expect(err).To(MatchError("the bosh command may be given either with --command or after --, not both"))
 			})
 		})
 	})
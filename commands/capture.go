@@ -0,0 +1,132 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// ExecuteOnRemoteOutput is the captured result of a remote command.
+// ExitStatus is -1 when the command did not run to completion, for example
+// because the connection failed.
+type ExecuteOnRemoteOutput struct {
+	Stdout          []byte
+	Stderr          []byte
+	StdoutTruncated bool
+	StderrTruncated bool
+	ExitStatus      int
+	Duration        time.Duration
+}
+
+// CaptureOnRemote runs the command like ExecuteOnRemote, but returns its
+// output instead of streaming it, unless input.Tee is set. The output is
+// returned along with any error, so that callers can inspect what a failed
+// command printed.
+func (s *sshClient) CaptureOnRemote(input ExecuteOnRemoteInput) (ExecuteOnRemoteOutput, error) {
+	return s.capture(input, s.ExecuteOnRemote)
+}
+
+// CaptureOnRemote runs input.Command in a new session, like
+// sshClient.CaptureOnRemote.
+func (c *sshConnection) CaptureOnRemote(input ExecuteOnRemoteInput) (ExecuteOnRemoteOutput, error) {
+	return c.sshClient.capture(input, c.ExecuteOnRemote)
+}
+
+func (s *sshClient) capture(input ExecuteOnRemoteInput, execute func(ExecuteOnRemoteInput) error) (ExecuteOnRemoteOutput, error) {
+	stdout := &cappedBuffer{limit: input.CaptureLimit}
+	stderr := &cappedBuffer{limit: input.CaptureLimit}
+
+	run := input
+	run.Stdout, run.Stderr = stdout, stderr
+
+	if input.Tee {
+		streamStdout, streamStderr := input.Stdout, input.Stderr
+		if streamStdout == nil {
+			w := newLoggerWriter(s.stdout)
+			defer w.Flush()
+			streamStdout = w
+		}
+		if streamStderr == nil {
+			w := newLoggerWriter(s.stderr)
+			defer w.Flush()
+			streamStderr = w
+		}
+
+		run.Stdout = io.MultiWriter(stdout, streamStdout)
+		run.Stderr = io.MultiWriter(stderr, streamStderr)
+	}
+
+	start := time.Now()
+	err := execute(run)
+
+	output := ExecuteOnRemoteOutput{
+		ExitStatus: exitStatus(err),
+		Duration:   time.Since(start),
+	}
+	output.Stdout, output.StdoutTruncated = stdout.contents()
+	output.Stderr, output.StderrTruncated = stderr.contents()
+
+	return output, err
+}
+
+func exitStatus(err error) int {
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case RemoteExitError:
+		return err.ExitStatus
+	case InterruptedError:
+		return exitStatus(err.Err)
+	default:
+		return -1
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it, or everything if
+// limit is 0. Writes never fail, so that the remote command is not blocked
+// once the limit has been reached.
+type cappedBuffer struct {
+	limit int
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if b.limit > 0 && b.buf.Len()+len(p) > b.limit {
+		p = p[:b.limit-b.buf.Len()]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+
+	return n, nil
+}
+
+// contents returns what was kept and whether anything was dropped.
+func (b *cappedBuffer) contents() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]byte{}, b.buf.Bytes()...), b.truncated
+}
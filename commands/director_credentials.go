@@ -0,0 +1,129 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const boshCommandlineCredentialsPath = "/api/v0/deployed/director/credentials/bosh_commandline_credentials"
+
+// DirectorCredentials are what a bosh CLI on the Ops Manager VM needs to
+// talk to the director.
+type DirectorCredentials struct {
+	Address      string
+	Client       string
+	ClientSecret string
+	CACertPath   string
+}
+
+// getDirectorCredentials reads the director credentials from the Ops
+// Manager credentials API, falling back to the director manifest on Ops
+// Managers that do not have it or when the credentials are incomplete.
+// Errors that the manifest would fail with too are returned as they are.
+func getDirectorCredentials(rs requestService) (DirectorCredentials, error) {
+	creds, err := getBoshCommandlineCredentials(rs)
+	switch err.(type) {
+	case nil:
+		return creds, nil
+	case UnauthorizedError, ForbiddenError, ServerError, APIError:
+		return DirectorCredentials{}, err
+	}
+
+	creds, manifestErr := getManifestCredentials(rs)
+	if manifestErr != nil {
+		return DirectorCredentials{}, fmt.Errorf("could not get the director credentials: %s; falling back to the director manifest: %s", err, manifestErr)
+	}
+
+	return creds, nil
+}
+
+// getBoshCommandlineCredentials parses the credentials API's bosh command
+// line, which looks like
+//
+//	BOSH_CLIENT=ops_manager BOSH_CLIENT_SECRET=... BOSH_CA_CERT=... BOSH_ENVIRONMENT=... bosh
+func getBoshCommandlineCredentials(rs requestService) (DirectorCredentials, error) {
+	var response struct {
+		Credential string `json:"credential"`
+	}
+	if err := getJSON(rs, boshCommandlineCredentialsPath, &response); err != nil {
+		return DirectorCredentials{}, err
+	}
+
+	creds := DirectorCredentials{CACertPath: rootCACertificatePath}
+	for _, field := range strings.Fields(response.Credential) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "BOSH_ENVIRONMENT":
+			creds.Address = kv[1]
+		case "BOSH_CLIENT":
+			creds.Client = kv[1]
+		case "BOSH_CLIENT_SECRET":
+			creds.ClientSecret = kv[1]
+		case "BOSH_CA_CERT":
+			creds.CACertPath = kv[1]
+		}
+	}
+
+	if err := creds.validate(); err != nil {
+		return DirectorCredentials{}, fmt.Errorf("the bosh commandline credentials have %s", err)
+	}
+
+	return creds, nil
+}
+
+func getManifestCredentials(rs requestService) (DirectorCredentials, error) {
+	manifest, err := getDirectorManifest(rs)
+	if err != nil {
+		return DirectorCredentials{}, err
+	}
+	if len(manifest.Jobs) == 0 {
+		return DirectorCredentials{}, errors.New("the director manifest has no jobs")
+	}
+
+	properties := manifest.Jobs[0].Properties
+	creds := DirectorCredentials{
+		Address:      properties.Director.Address,
+		Client:       "ops_manager",
+		ClientSecret: properties.Uaa.Clients.OpsManager.Secret,
+		CACertPath:   rootCACertificatePath,
+	}
+
+	if err := creds.validate(); err != nil {
+		return DirectorCredentials{}, fmt.Errorf("the director manifest has %s", err)
+	}
+
+	return creds, nil
+}
+
+func (c DirectorCredentials) validate() error {
+	switch {
+	case c.Address == "":
+		return errors.New("no director address")
+	case c.Client == "":
+		return errors.New("no client")
+	case c.ClientSecret == "":
+		return errors.New("no client secret")
+	default:
+		return nil
+	}
+}
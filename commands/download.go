@@ -0,0 +1,88 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pivotal-cf/om/commands"
+)
+
+type Download struct {
+	ssh        SSHClient
+	progress   progress
+	stdout     logger
+	stderr     logger
+	host       string
+	SSHOptions SSHOptions
+	Options    struct {
+		RemotePath         string        `short:"r" long:"remote-path" description:"path of the file to download, relative to the ssh user's home directory"`
+		File               string        `short:"f" long:"file" description:"path to download the file to (defaults to the file's name, in the current directory)"`
+		TransferRetries    int           `long:"transfer-retries" description:"number of times to resume a failed download" default:"5"`
+		TransferRetryDelay time.Duration `long:"transfer-retry-delay" description:"how long to wait before first resuming a failed download, doubling with each attempt" default:"1s"`
+	}
+}
+
+func NewDownloadCommand(ssh SSHClient, host string, progress progress, stdout, stderr logger) Download {
+	return Download{ssh: ssh, host: host, progress: progress, stdout: stdout, stderr: stderr}
+}
+
+func (d Download) Usage() commands.Usage {
+	return commands.Usage{
+		Description:      "Downloads a file from the OpsManager VM and verifies its checksum",
+		ShortDescription: "Downloads a file from the OpsManager VM",
+		Flags:            usageFlags(&d.SSHOptions, &d.Options),
+	}
+}
+
+func (d Download) Execute(args []string) error {
+	_, err := parseFlags(args, &d.SSHOptions, &d.Options)
+	if err != nil {
+		return fmt.Errorf("could not parse download flags: %s", err)
+	}
+
+	if d.Options.RemotePath == "" {
+		return fmt.Errorf("a remote path to download must be provided")
+	}
+
+	localPath := d.Options.File
+	if localPath == "" {
+		localPath = path.Base(d.Options.RemotePath)
+	}
+
+	input, err := d.SSHOptions.input(d.host)
+	if err != nil {
+		return err
+	}
+
+	sum, err := transfer{
+		ssh:        d.ssh,
+		input:      input,
+		progress:   d.progress,
+		stderr:     d.stderr,
+		retries:    d.Options.TransferRetries,
+		retryDelay: d.Options.TransferRetryDelay,
+	}.download(d.Options.RemotePath, localPath)
+	if err != nil {
+		return err
+	}
+
+	d.stdout.Printf("Downloaded %s to %s (sha256 %s)\n", d.Options.RemotePath, localPath, sum)
+
+	return nil
+}
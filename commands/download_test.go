@@ -0,0 +1,184 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	apifakes "github.com/pivotal-cf/om/api/fakes"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Download", func() {
+	Describe("Execute", func() {
+		var (
+			server     *testSSHServer
+			tmpDir     string
+			remoteFile string
+			localFile  string
+			progress   *apifakes.Progress
+			barred     *bytes.Buffer
+			stdout     *omfakes.Logger
+			stderr     *omfakes.Logger
+			command    commands.Download
+			args       []string
+		)
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "download")
+			Expect(err).ToNot(HaveOccurred())
+
+			remoteFile = filepath.Join(tmpDir, "logs.tgz")
+			Expect(ioutil.WriteFile(remoteFile, []byte("bosh logs"), 0644)).To(Succeed())
+			localFile = filepath.Join(tmpDir, "downloaded.tgz")
+
+			server = newTestSSHServer()
+			server.handler = server.runInShell(tmpDir)
+
+			barred = &bytes.Buffer{}
+			progress = &apifakes.Progress{}
+			progress.NewBarReaderStub = func(r io.Reader) io.Reader {
+				return io.TeeReader(r, barred)
+			}
+			stdout = &omfakes.Logger{}
+			stderr = &omfakes.Logger{}
+
+			command = commands.NewDownloadCommand(commands.NewSSHClient(stdout, stdout), "127.0.0.1", progress, stdout, stderr)
+			args = []string{
+				"--ssh-password", "secret",
+				"--ssh-port", strconv.Itoa(server.port()),
+				"--ssh-host-key-fingerprint", server.fingerprint(),
+				"--remote-path", remoteFile,
+				"--file", localFile,
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(tmpDir)
+		})
+
+		It("downloads the file and verifies its checksum", func() {
+			Expect(command.Execute(args)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(localFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("bosh logs"))
+
+			Expect(progress.SetTotalArgsForCall(0)).To(Equal(int64(len("bosh logs"))))
+			Expect(progress.KickoffCallCount()).To(Equal(1))
+			Expect(progress.EndCallCount()).To(Equal(1))
+			Expect(barred.String()).To(Equal("bosh logs"))
+
+			format, v := stdout.PrintfArgsForCall(stdout.PrintfCallCount() - 1)
+			Expect(fmt.Sprintf(format, v...)).To(HavePrefix(fmt.Sprintf("Downloaded %s to %s (sha256 ", remoteFile, localFile)))
+		})
+
+		It("resumes from a partial download", func() {
+			Expect(ioutil.WriteFile(localFile+".part", []byte("bosh "), 0644)).To(Succeed())
+
+			Expect(command.Execute(args)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(localFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("bosh logs"))
+			Expect(localFile + ".part").ToNot(BeAnExistingFile())
+
+			Expect(server.Commands()).To(ContainElement("tail -c +6 " + remoteFile))
+			Expect(progress.SetTotalArgsForCall(0)).To(Equal(int64(len("bosh logs"))))
+			Expect(barred.Len()).To(Equal(len("bosh logs")))
+
+			format, v := stderr.PrintfArgsForCall(0)
+			Expect(fmt.Sprintf(format, v...)).To(Equal(fmt.Sprintf("Resuming download of %s at byte 5\n", remoteFile)))
+		})
+
+		It("starts again when the partial download is larger than the remote file", func() {
+			Expect(ioutil.WriteFile(localFile+".part", []byte("much older bosh logs"), 0644)).To(Succeed())
+
+			Expect(command.Execute(args)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(localFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("bosh logs"))
+		})
+
+		It("starts again when the checksums do not match", func() {
+			shell := server.handler
+			lied := false
+			server.handler = func(command string, channel ssh.Channel) uint32 {
+				if strings.HasPrefix(command, "sha256sum") && !lied {
+					lied = true
+					io.WriteString(channel, "0000  logs.tgz\n")
+					return 0
+				}
+				return shell(command, channel)
+			}
+
+			Expect(command.Execute(append(args, "--transfer-retry-delay", "1ms"))).To(Succeed())
+
+			contents, err := ioutil.ReadFile(localFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("bosh logs"))
+			Expect(progress.KickoffCallCount()).To(Equal(1))
+			Expect(progress.EndCallCount()).To(Equal(1))
+			Expect(barred.Len()).To(Equal(len("bosh logs")))
+		})
+
+		It("gives up when the checksums keep not matching", func() {
+			shell := server.handler
+			server.handler = func(command string, channel ssh.Channel) uint32 {
+				if strings.HasPrefix(command, "sha256sum") {
+					io.WriteString(channel, "0000  logs.tgz\n")
+					return 0
+				}
+				return shell(command, channel)
+			}
+
+			err := command.Execute(append(args, "--transfer-retries", "1", "--transfer-retry-delay", "1ms"))
+			Expect(err).To(BeAssignableToTypeOf(commands.ChecksumMismatchError{}))
+			Expect(progress.KickoffCallCount()).To(Equal(1))
+			Expect(progress.EndCallCount()).To(Equal(1))
+			Expect(localFile).ToNot(BeAnExistingFile())
+			Expect(localFile + ".part").ToNot(BeAnExistingFile())
+		})
+
+		It("reports a missing remote file", func() {
+			args[len(args)-3] = filepath.Join(tmpDir, "missing.tgz")
+
+			err := command.Execute(args)
+			Expect(err).To(MatchError(ContainSubstring("could not find the size of " + filepath.Join(tmpDir, "missing.tgz"))))
+			Expect(localFile).ToNot(BeAnExistingFile())
+		})
+
+		It("fails when no remote path is given", func() {
+			err := command.Execute([]string{"--ssh-password", "secret"})
+			Expect(err).To(MatchError("a remote path to download must be provided"))
+		})
+	})
+})
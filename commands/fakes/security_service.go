@@ -0,0 +1,62 @@
+// This file was generated by counterfeiter
+package fakes
+
+import "sync"
+
+type SecurityService struct {
+	FetchRootCACertStub        func() (string, error)
+	fetchRootCACertMutex       sync.RWMutex
+	fetchRootCACertArgsForCall []struct{}
+	fetchRootCACertReturns     struct {
+		result1 string
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *SecurityService) FetchRootCACert() (string, error) {
+	fake.fetchRootCACertMutex.Lock()
+	fake.fetchRootCACertArgsForCall = append(fake.fetchRootCACertArgsForCall, struct{}{})
+	fake.recordInvocation("FetchRootCACert", []interface{}{})
+	fake.fetchRootCACertMutex.Unlock()
+	if fake.FetchRootCACertStub != nil {
+		return fake.FetchRootCACertStub()
+	} else {
+		return fake.fetchRootCACertReturns.result1, fake.fetchRootCACertReturns.result2
+	}
+}
+
+func (fake *SecurityService) FetchRootCACertCallCount() int {
+	fake.fetchRootCACertMutex.RLock()
+	defer fake.fetchRootCACertMutex.RUnlock()
+	return len(fake.fetchRootCACertArgsForCall)
+}
+
+func (fake *SecurityService) FetchRootCACertReturns(result1 string, result2 error) {
+	fake.FetchRootCACertStub = nil
+	fake.fetchRootCACertReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *SecurityService) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.fetchRootCACertMutex.RLock()
+	defer fake.fetchRootCACertMutex.RUnlock()
+	return fake.invocations
+}
+
+func (fake *SecurityService) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
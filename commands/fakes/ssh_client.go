@@ -16,6 +16,24 @@ type SSHClient struct {
 	executeOnRemoteReturns struct {
 		result1 error
 	}
+	CaptureOnRemoteStub        func(input commands.ExecuteOnRemoteInput) (commands.ExecuteOnRemoteOutput, error)
+	captureOnRemoteMutex       sync.RWMutex
+	captureOnRemoteArgsForCall []struct {
+		input commands.ExecuteOnRemoteInput
+	}
+	captureOnRemoteReturns struct {
+		result1 commands.ExecuteOnRemoteOutput
+		result2 error
+	}
+	ConnectStub        func(input commands.ExecuteOnRemoteInput) (commands.SSHConnection, error)
+	connectMutex       sync.RWMutex
+	connectArgsForCall []struct {
+		input commands.ExecuteOnRemoteInput
+	}
+	connectReturns struct {
+		result1 commands.SSHConnection
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -53,11 +71,83 @@ func (fake *SSHClient) ExecuteOnRemoteReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *SSHClient) CaptureOnRemote(input commands.ExecuteOnRemoteInput) (commands.ExecuteOnRemoteOutput, error) {
+	fake.captureOnRemoteMutex.Lock()
+	fake.captureOnRemoteArgsForCall = append(fake.captureOnRemoteArgsForCall, struct {
+		input commands.ExecuteOnRemoteInput
+	}{input})
+	fake.recordInvocation("CaptureOnRemote", []interface{}{input})
+	fake.captureOnRemoteMutex.Unlock()
+	if fake.CaptureOnRemoteStub != nil {
+		return fake.CaptureOnRemoteStub(input)
+	} else {
+		return fake.captureOnRemoteReturns.result1, fake.captureOnRemoteReturns.result2
+	}
+}
+
+func (fake *SSHClient) CaptureOnRemoteCallCount() int {
+	fake.captureOnRemoteMutex.RLock()
+	defer fake.captureOnRemoteMutex.RUnlock()
+	return len(fake.captureOnRemoteArgsForCall)
+}
+
+func (fake *SSHClient) CaptureOnRemoteArgsForCall(i int) commands.ExecuteOnRemoteInput {
+	fake.captureOnRemoteMutex.RLock()
+	defer fake.captureOnRemoteMutex.RUnlock()
+	return fake.captureOnRemoteArgsForCall[i].input
+}
+
+func (fake *SSHClient) CaptureOnRemoteReturns(result1 commands.ExecuteOnRemoteOutput, result2 error) {
+	fake.CaptureOnRemoteStub = nil
+	fake.captureOnRemoteReturns = struct {
+		result1 commands.ExecuteOnRemoteOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *SSHClient) Connect(input commands.ExecuteOnRemoteInput) (commands.SSHConnection, error) {
+	fake.connectMutex.Lock()
+	fake.connectArgsForCall = append(fake.connectArgsForCall, struct {
+		input commands.ExecuteOnRemoteInput
+	}{input})
+	fake.recordInvocation("Connect", []interface{}{input})
+	fake.connectMutex.Unlock()
+	if fake.ConnectStub != nil {
+		return fake.ConnectStub(input)
+	} else {
+		return fake.connectReturns.result1, fake.connectReturns.result2
+	}
+}
+
+func (fake *SSHClient) ConnectCallCount() int {
+	fake.connectMutex.RLock()
+	defer fake.connectMutex.RUnlock()
+	return len(fake.connectArgsForCall)
+}
+
+func (fake *SSHClient) ConnectArgsForCall(i int) commands.ExecuteOnRemoteInput {
+	fake.connectMutex.RLock()
+	defer fake.connectMutex.RUnlock()
+	return fake.connectArgsForCall[i].input
+}
+
+func (fake *SSHClient) ConnectReturns(result1 commands.SSHConnection, result2 error) {
+	fake.ConnectStub = nil
+	fake.connectReturns = struct {
+		result1 commands.SSHConnection
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *SSHClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.executeOnRemoteMutex.RLock()
 	defer fake.executeOnRemoteMutex.RUnlock()
+	fake.captureOnRemoteMutex.RLock()
+	defer fake.captureOnRemoteMutex.RUnlock()
+	fake.connectMutex.RLock()
+	defer fake.connectMutex.RUnlock()
 	return fake.invocations
 }
 
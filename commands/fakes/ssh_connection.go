@@ -0,0 +1,239 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+)
+
+type SSHConnection struct {
+	ExecuteOnRemoteStub        func(input commands.ExecuteOnRemoteInput) error
+	executeOnRemoteMutex       sync.RWMutex
+	executeOnRemoteArgsForCall []struct {
+		input commands.ExecuteOnRemoteInput
+	}
+	executeOnRemoteReturns struct {
+		result1 error
+	}
+	CaptureOnRemoteStub        func(input commands.ExecuteOnRemoteInput) (commands.ExecuteOnRemoteOutput, error)
+	captureOnRemoteMutex       sync.RWMutex
+	captureOnRemoteArgsForCall []struct {
+		input commands.ExecuteOnRemoteInput
+	}
+	captureOnRemoteReturns struct {
+		result1 commands.ExecuteOnRemoteOutput
+		result2 error
+	}
+	CloseStub        func() error
+	closeMutex       sync.RWMutex
+	closeArgsForCall []struct{}
+	closeReturns     struct {
+		result1 error
+	}
+	DialStub        func(network, address string) (net.Conn, error)
+	dialMutex       sync.RWMutex
+	dialArgsForCall []struct {
+		network string
+		address string
+	}
+	dialReturns struct {
+		result1 net.Conn
+		result2 error
+	}
+	WaitStub        func() error
+	waitMutex       sync.RWMutex
+	waitArgsForCall []struct{}
+	waitReturns     struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *SSHConnection) ExecuteOnRemote(input commands.ExecuteOnRemoteInput) error {
+	fake.executeOnRemoteMutex.Lock()
+	fake.executeOnRemoteArgsForCall = append(fake.executeOnRemoteArgsForCall, struct {
+		input commands.ExecuteOnRemoteInput
+	}{input})
+	fake.recordInvocation("ExecuteOnRemote", []interface{}{input})
+	fake.executeOnRemoteMutex.Unlock()
+	if fake.ExecuteOnRemoteStub != nil {
+		return fake.ExecuteOnRemoteStub(input)
+	} else {
+		return fake.executeOnRemoteReturns.result1
+	}
+}
+
+func (fake *SSHConnection) ExecuteOnRemoteCallCount() int {
+	fake.executeOnRemoteMutex.RLock()
+	defer fake.executeOnRemoteMutex.RUnlock()
+	return len(fake.executeOnRemoteArgsForCall)
+}
+
+func (fake *SSHConnection) ExecuteOnRemoteArgsForCall(i int) commands.ExecuteOnRemoteInput {
+	fake.executeOnRemoteMutex.RLock()
+	defer fake.executeOnRemoteMutex.RUnlock()
+	return fake.executeOnRemoteArgsForCall[i].input
+}
+
+func (fake *SSHConnection) ExecuteOnRemoteReturns(result1 error) {
+	fake.ExecuteOnRemoteStub = nil
+	fake.executeOnRemoteReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *SSHConnection) CaptureOnRemote(input commands.ExecuteOnRemoteInput) (commands.ExecuteOnRemoteOutput, error) {
+	fake.captureOnRemoteMutex.Lock()
+	fake.captureOnRemoteArgsForCall = append(fake.captureOnRemoteArgsForCall, struct {
+		input commands.ExecuteOnRemoteInput
+	}{input})
+	fake.recordInvocation("CaptureOnRemote", []interface{}{input})
+	fake.captureOnRemoteMutex.Unlock()
+	if fake.CaptureOnRemoteStub != nil {
+		return fake.CaptureOnRemoteStub(input)
+	} else {
+		return fake.captureOnRemoteReturns.result1, fake.captureOnRemoteReturns.result2
+	}
+}
+
+func (fake *SSHConnection) CaptureOnRemoteCallCount() int {
+	fake.captureOnRemoteMutex.RLock()
+	defer fake.captureOnRemoteMutex.RUnlock()
+	return len(fake.captureOnRemoteArgsForCall)
+}
+
+func (fake *SSHConnection) CaptureOnRemoteArgsForCall(i int) commands.ExecuteOnRemoteInput {
+	fake.captureOnRemoteMutex.RLock()
+	defer fake.captureOnRemoteMutex.RUnlock()
+	return fake.captureOnRemoteArgsForCall[i].input
+}
+
+func (fake *SSHConnection) CaptureOnRemoteReturns(result1 commands.ExecuteOnRemoteOutput, result2 error) {
+	fake.CaptureOnRemoteStub = nil
+	fake.captureOnRemoteReturns = struct {
+		result1 commands.ExecuteOnRemoteOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *SSHConnection) Close() error {
+	fake.closeMutex.Lock()
+	fake.closeArgsForCall = append(fake.closeArgsForCall, struct{}{})
+	fake.recordInvocation("Close", []interface{}{})
+	fake.closeMutex.Unlock()
+	if fake.CloseStub != nil {
+		return fake.CloseStub()
+	} else {
+		return fake.closeReturns.result1
+	}
+}
+
+func (fake *SSHConnection) CloseCallCount() int {
+	fake.closeMutex.RLock()
+	defer fake.closeMutex.RUnlock()
+	fake.dialMutex.RLock()
+	defer fake.dialMutex.RUnlock()
+	fake.waitMutex.RLock()
+	defer fake.waitMutex.RUnlock()
+	return len(fake.closeArgsForCall)
+}
+
+func (fake *SSHConnection) CloseReturns(result1 error) {
+	fake.CloseStub = nil
+	fake.closeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *SSHConnection) Dial(network string, address string) (net.Conn, error) {
+	fake.dialMutex.Lock()
+	fake.dialArgsForCall = append(fake.dialArgsForCall, struct {
+		network string
+		address string
+	}{network, address})
+	fake.recordInvocation("Dial", []interface{}{network, address})
+	fake.dialMutex.Unlock()
+	if fake.DialStub != nil {
+		return fake.DialStub(network, address)
+	} else {
+		return fake.dialReturns.result1, fake.dialReturns.result2
+	}
+}
+
+func (fake *SSHConnection) DialCallCount() int {
+	fake.dialMutex.RLock()
+	defer fake.dialMutex.RUnlock()
+	return len(fake.dialArgsForCall)
+}
+
+func (fake *SSHConnection) DialArgsForCall(i int) (string, string) {
+	fake.dialMutex.RLock()
+	defer fake.dialMutex.RUnlock()
+	return fake.dialArgsForCall[i].network, fake.dialArgsForCall[i].address
+}
+
+func (fake *SSHConnection) DialReturns(result1 net.Conn, result2 error) {
+	fake.DialStub = nil
+	fake.dialReturns = struct {
+		result1 net.Conn
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *SSHConnection) Wait() error {
+	fake.waitMutex.Lock()
+	fake.waitArgsForCall = append(fake.waitArgsForCall, struct{}{})
+	fake.recordInvocation("Wait", []interface{}{})
+	fake.waitMutex.Unlock()
+	if fake.WaitStub != nil {
+		return fake.WaitStub()
+	} else {
+		return fake.waitReturns.result1
+	}
+}
+
+func (fake *SSHConnection) WaitCallCount() int {
+	fake.waitMutex.RLock()
+	defer fake.waitMutex.RUnlock()
+	return len(fake.waitArgsForCall)
+}
+
+func (fake *SSHConnection) WaitReturns(result1 error) {
+	fake.WaitStub = nil
+	fake.waitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *SSHConnection) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.executeOnRemoteMutex.RLock()
+	defer fake.executeOnRemoteMutex.RUnlock()
+	fake.captureOnRemoteMutex.RLock()
+	defer fake.captureOnRemoteMutex.RUnlock()
+	fake.closeMutex.RLock()
+	defer fake.closeMutex.RUnlock()
+	fake.dialMutex.RLock()
+	defer fake.dialMutex.RUnlock()
+	fake.waitMutex.RLock()
+	defer fake.waitMutex.RUnlock()
+	return fake.invocations
+}
+
+func (fake *SSHConnection) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ commands.SSHConnection = new(SSHConnection)
@@ -0,0 +1,147 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pivotal-cf/om/flags"
+)
+
+// parseFlags parses args into each of receivers, which must be pointers to
+// structs of flags. flags.Parse takes a single flat struct, so the fields of
+// all of them are gathered into one for parsing and copied back afterwards.
+// It returns the arguments that remain after the flags.
+//
+// flags.Parse keeps only the last value of a flag given more than once, so
+// the values of repeated string flags tagged `repeatable:"true"` are joined
+// with commas first.
+func parseFlags(args []string, receivers ...interface{}) ([]string, error) {
+	merged := mergeFlags(receivers)
+
+	rest, err := flags.Parse(merged.Interface(), joinRepeatedFlags(args, merged.Elem().Type()))
+	if err != nil {
+		return nil, err
+	}
+
+	field := 0
+	for _, receiver := range receivers {
+		v := reflect.ValueOf(receiver).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			v.Field(i).Set(merged.Elem().Field(field))
+			field++
+		}
+	}
+
+	return rest, nil
+}
+
+// usageFlags returns the flags of all of receivers as a single struct, for
+// commands.Usage.
+func usageFlags(receivers ...interface{}) interface{} {
+	return mergeFlags(receivers).Elem().Interface()
+}
+
+func mergeFlags(receivers []interface{}) reflect.Value {
+	var fields []reflect.StructField
+	for _, receiver := range receivers {
+		t := reflect.TypeOf(receiver).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			fields = append(fields, reflect.StructField{
+				Name: t.Field(i).Name,
+				Type: t.Field(i).Type,
+				Tag:  t.Field(i).Tag,
+			})
+		}
+	}
+
+	return reflect.New(reflect.StructOf(fields))
+}
+
+// joinRepeatedFlags gathers every value given to a repeatable flag of t into
+// a single comma separated one, leaving the other arguments in order. Like
+// flag.Parse, it stops at the first non-flag argument or at "--".
+func joinRepeatedFlags(args []string, t reflect.Type) []string {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		for _, tag := range []string{"short", "long"} {
+			if name, ok := t.Field(i).Tag.Lookup(tag); ok {
+				fields[name] = t.Field(i)
+			}
+		}
+	}
+
+	var (
+		joined []string
+		values = map[string][]string{}
+		rest   []string
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if equals := strings.Index(name, "="); equals >= 0 {
+			name, value, hasValue = name[:equals], name[equals+1:], true
+		}
+
+		field, ok := fields[name]
+		if !ok || field.Type.Kind() == reflect.Bool {
+			rest = append(rest, arg)
+			continue
+		}
+
+		if field.Tag.Get("repeatable") != "true" {
+			rest = append(rest, arg)
+			if !hasValue && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+
+		if !hasValue {
+			if i+1 == len(args) {
+				rest = append(rest, arg)
+				continue
+			}
+			i++
+			value = args[i]
+		}
+
+		if _, seen := values[field.Name]; !seen {
+			joined = append(joined, field.Name)
+		}
+		values[field.Name] = append(values[field.Name], value)
+	}
+
+	var parsed []string
+	for _, name := range joined {
+		field, _ := t.FieldByName(name)
+		flag := field.Tag.Get("long")
+		if flag == "" {
+			flag = field.Tag.Get("short")
+		}
+		parsed = append(parsed, "--"+flag+"="+strings.Join(values[name], ","))
+	}
+
+	return append(parsed, rest...)
+}
@@ -0,0 +1,267 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pivotal-cf/om/commands"
+)
+
+type Forward struct {
+	ssh        SSHClient
+	stderr     logger
+	host       string
+	SSHOptions SSHOptions
+	Options    struct {
+		Forwards string `short:"L" long:"forward" repeatable:"true" description:"comma separated [bind_address:]port:host:hostport forwards, as with ssh -L; may be repeated"`
+	}
+
+	// Interrupts close the forwards. When nil, SIGINT and SIGTERM received
+	// by this process are used.
+	Interrupts <-chan os.Signal
+}
+
+func NewForwardCommand(ssh SSHClient, host string, stderr logger) Forward {
+	return Forward{ssh: ssh, host: host, stderr: stderr}
+}
+
+func (f Forward) Usage() commands.Usage {
+	return commands.Usage{
+		Description:      "Forwards local ports to hosts reachable from the OpsManager VM, reconnecting if the ssh connection drops",
+		ShortDescription: "Forwards local ports through the OpsManager VM",
+		Flags:            usageFlags(&f.SSHOptions, &f.Options),
+	}
+}
+
+// Execute relays connections to each forward's local address until
+// interrupted.
+func (f Forward) Execute(args []string) error {
+	_, err := parseFlags(args, &f.SSHOptions, &f.Options)
+	if err != nil {
+		return fmt.Errorf("could not parse forward flags: %s", err)
+	}
+
+	forwards, err := ParsePortForwards(f.Options.Forwards)
+	if err != nil {
+		return err
+	}
+	if len(forwards) == 0 {
+		return errors.New("at least one forward must be provided with -L")
+	}
+
+	input, err := f.SSHOptions.input(f.host)
+	if err != nil {
+		return err
+	}
+
+	interrupts := f.Interrupts
+	if interrupts == nil {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(c)
+		interrupts = c
+	}
+
+	conn, err := f.ssh.Connect(input)
+	if err != nil {
+		return err
+	}
+
+	r := &relay{ssh: f.ssh, input: input, stderr: f.stderr, conn: conn, stopped: make(chan struct{})}
+
+	var listeners []net.Listener
+	for _, forward := range forwards {
+		listener, err := net.Listen("tcp", forward.Listen)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			conn.Close()
+			return fmt.Errorf("could not listen on %s: %s", forward.Listen, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	stats := make([]*forwardStats, len(forwards))
+	for i, listener := range listeners {
+		stats[i] = &forwardStats{PortForward: forwards[i]}
+		stats[i].Listen = listener.Addr().String()
+		f.stderr.Printf("Forwarding %s through the OpsManager VM\n", stats[i])
+		r.running.Add(1)
+		go r.serve(listener, stats[i])
+	}
+
+	go r.reconnect()
+
+	<-interrupts
+	f.stderr.Printf("Closing the forwards\n")
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	r.stop()
+
+	for _, s := range stats {
+		f.stderr.Printf("Closed %s after %d connections\n", s, s.count())
+	}
+
+	return nil
+}
+
+// forwardStats counts the connections made to a forward.
+type forwardStats struct {
+	PortForward
+
+	mu     sync.Mutex
+	active int
+	total  int
+}
+
+func (s *forwardStats) open() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active++
+	s.total++
+	return s.active, s.total
+}
+
+func (s *forwardStats) close() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	return s.active
+}
+
+func (s *forwardStats) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// relay dials forwarded connections over the current ssh connection, and
+// replaces that connection when it drops.
+type relay struct {
+	ssh    SSHClient
+	input  ExecuteOnRemoteInput
+	stderr logger
+
+	mu      sync.Mutex
+	conn    SSHConnection
+	stopped chan struct{}
+	running sync.WaitGroup
+}
+
+func (r *relay) current() SSHConnection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn
+}
+
+// serve relays the connections accepted by listener to the forward's target
+// until listener is closed.
+func (r *relay) serve(listener net.Listener, stats *forwardStats) {
+	defer r.running.Done()
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		r.running.Add(1)
+		go func() {
+			defer r.running.Done()
+
+			remote, err := r.current().Dial("tcp", stats.Target)
+			if err != nil {
+				r.stderr.Printf("Could not connect to %s through the OpsManager VM: %s\n", stats.Target, err)
+				client.Close()
+				return
+			}
+
+			active, total := stats.open()
+			r.stderr.Printf("%s: connection opened (%d active, %d total)\n", stats, active, total)
+
+			pipe(client, remote)
+
+			r.stderr.Printf("%s: connection closed (%d active)\n", stats, stats.close())
+		}()
+	}
+}
+
+// reconnect waits for the ssh connection to drop and replaces it, retrying
+// with backoff until it succeeds or the relay is stopped.
+func (r *relay) reconnect() {
+	retry := retryPolicy{delay: defaultRetryDelay}
+
+	for {
+		err := r.current().Wait()
+
+		select {
+		case <-r.stopped:
+			return
+		default:
+		}
+
+		r.stderr.Printf("Lost the connection to the OpsManager VM: %v; reconnecting\n", err)
+
+		for attempt := 1; ; attempt++ {
+			conn, err := r.ssh.Connect(r.input)
+			if err == nil {
+				r.mu.Lock()
+				select {
+				case <-r.stopped:
+					r.mu.Unlock()
+					conn.Close()
+					return
+				default:
+				}
+				r.conn = conn
+				r.mu.Unlock()
+
+				r.stderr.Printf("Reconnected to the OpsManager VM\n")
+				break
+			}
+
+			delay := retry.backoff(attempt)
+			r.stderr.Printf("Could not reconnect to the OpsManager VM: %s; retrying in %s\n", err, delay)
+
+			select {
+			case <-r.stopped:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// stop closes the ssh connection, which ends the relayed connections, and
+// waits for them and the listeners, which must already be closed, to finish.
+func (r *relay) stop() {
+	r.mu.Lock()
+	close(r.stopped)
+	r.conn.Close()
+	r.mu.Unlock()
+
+	r.running.Wait()
+}
@@ -0,0 +1,220 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	"github.com/pivotal-cf/execute-on-opsman/commands/fakes"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Forward", func() {
+	Describe("Execute", func() {
+		var (
+			command    commands.Forward
+			sshClient  *fakes.SSHClient
+			stderr     *omfakes.Logger
+			echo       net.Listener
+			interrupts chan os.Signal
+		)
+
+		// newConnection returns a connection that dials the echo server and
+		// stays up until dropped is closed.
+		newConnection := func(dropped chan struct{}) *fakes.SSHConnection {
+			connection := &fakes.SSHConnection{}
+			connection.DialStub = func(network, address string) (net.Conn, error) {
+				return net.Dial(network, echo.Addr().String())
+			}
+			connection.WaitStub = func() error {
+				<-dropped
+				return io.EOF
+			}
+			connection.CloseStub = func() error {
+				select {
+				case <-dropped:
+				default:
+					close(dropped)
+				}
+				return nil
+			}
+			return connection
+		}
+
+		logged := func() []string {
+			var lines []string
+			for i := 0; i < stderr.PrintfCallCount(); i++ {
+				format, v := stderr.PrintfArgsForCall(i)
+				lines = append(lines, fmt.Sprintf(format, v...))
+			}
+			return lines
+		}
+
+		// start runs the forward in the background and returns its local
+		// address once it is listening.
+		start := func() (string, <-chan error) {
+			done := make(chan error, 1)
+			go func() {
+				done <- command.Execute([]string{"--ssh-password", "secret", "-L", "0:10.0.0.5:8844"})
+			}()
+
+			Eventually(stderr.PrintfCallCount).Should(BeNumerically(">=", 1))
+			Expect(logged()[0]).To(HaveSuffix(" -> 10.0.0.5:8844 through the OpsManager VM\n"))
+
+			address := strings.TrimPrefix(logged()[0], "Forwarding ")
+			return address[:strings.Index(address, " ")], done
+		}
+
+		ping := func(address string) {
+			conn, err := net.Dial("tcp", address)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("ping"))
+			Expect(err).ToNot(HaveOccurred())
+			pong := make([]byte, 4)
+			_, err = io.ReadFull(conn, pong)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(pong)).To(Equal("ping"))
+		}
+
+		BeforeEach(func() {
+			var err error
+			echo, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			go func(listener net.Listener) {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						io.Copy(conn, conn)
+						conn.Close()
+					}()
+				}
+			}(echo)
+
+			sshClient = &fakes.SSHClient{}
+			stderr = &omfakes.Logger{}
+			interrupts = make(chan os.Signal, 1)
+
+			command = commands.NewForwardCommand(sshClient, "pcf.example.com", stderr)
+			command.Interrupts = interrupts
+		})
+
+		AfterEach(func() {
+			echo.Close()
+		})
+
+		It("relays connections through the ssh connection until interrupted", func() {
+			connection := newConnection(make(chan struct{}))
+			sshClient.ConnectReturns(connection, nil)
+
+			address, done := start()
+			ping(address)
+
+			network, target := connection.DialArgsForCall(0)
+			Expect(network).To(Equal("tcp"))
+			Expect(target).To(Equal("10.0.0.5:8844"))
+			Eventually(logged).Should(ContainElement(HaveSuffix(": connection closed (0 active)\n")))
+			Expect(logged()).To(ContainElement(address + " -> 10.0.0.5:8844: connection opened (1 active, 1 total)\n"))
+
+			interrupts <- os.Interrupt
+			Eventually(done).Should(Receive(BeNil()))
+
+			Expect(connection.CloseCallCount()).To(Equal(1))
+			Expect(logged()).To(ContainElement("Closed " + address + " -> 10.0.0.5:8844 after 1 connections\n"))
+
+			_, err := net.Dial("tcp", address)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("reconnects when the ssh connection drops", func() {
+			dropped := make(chan struct{})
+			first := newConnection(dropped)
+			second := newConnection(make(chan struct{}))
+			sshClient.ConnectStub = func(commands.ExecuteOnRemoteInput) (commands.SSHConnection, error) {
+				if sshClient.ConnectCallCount() == 1 {
+					return first, nil
+				}
+				return second, nil
+			}
+
+			address, done := start()
+			close(dropped)
+
+			Eventually(logged).Should(ContainElement("Reconnected to the OpsManager VM\n"))
+			Expect(logged()).To(ContainElement("Lost the connection to the OpsManager VM: EOF; reconnecting\n"))
+
+			ping(address)
+			Expect(first.DialCallCount()).To(Equal(0))
+			Expect(second.DialCallCount()).To(Equal(1))
+
+			interrupts <- os.Interrupt
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(second.CloseCallCount()).To(Equal(1))
+		})
+
+		It("forwards every -L when the flag is repeated", func() {
+			connection := newConnection(make(chan struct{}))
+			sshClient.ConnectReturns(connection, nil)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- command.Execute([]string{"--ssh-password", "secret", "-L", "0:10.0.0.5:8844", "--forward", "0:10.0.0.6:8443"})
+			}()
+
+			Eventually(stderr.PrintfCallCount).Should(Equal(2))
+			Expect(logged()[0]).To(HaveSuffix(" -> 10.0.0.5:8844 through the OpsManager VM\n"))
+			Expect(logged()[1]).To(HaveSuffix(" -> 10.0.0.6:8443 through the OpsManager VM\n"))
+
+			interrupts <- os.Interrupt
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("requires a forward", func() {
+			err := command.Execute([]string{"--ssh-password", "secret"})
+			Expect(err).To(MatchError("at least one forward must be provided with -L"))
+			Expect(sshClient.ConnectCallCount()).To(Equal(0))
+		})
+
+		It("returns the connection error", func() {
+			sshClient.ConnectReturns(nil, commands.DialError{Address: "pcf.example.com:22", Err: errors.New("refused")})
+
+			err := command.Execute([]string{"--ssh-password", "secret", "-L", "0:10.0.0.5:8844"})
+			Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+		})
+
+		It("closes the connection when it cannot listen", func() {
+			connection := newConnection(make(chan struct{}))
+			sshClient.ConnectReturns(connection, nil)
+
+			err := command.Execute([]string{"--ssh-password", "secret", "-L", echo.Addr().String() + ":10.0.0.5:8844"})
+			Expect(err).To(MatchError(ContainSubstring("could not listen on " + echo.Addr().String())))
+			Expect(connection.CloseCallCount()).To(Equal(1))
+		})
+	})
+})
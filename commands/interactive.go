@@ -0,0 +1,104 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var errNotATerminal = errors.New("interactive mode requires stdin to be a terminal")
+
+// RFC 4254 Section 6.7.
+type windowChangeMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+func checkInteractive() error {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return errNotATerminal
+	}
+
+	return nil
+}
+
+// startInteractive requests a pty sized to the local terminal, puts the local
+// terminal in raw mode and forwards stdin and window size changes to the
+// session. The returned function restores the local terminal.
+func startInteractive(session *ssh.Session) (func(), error) {
+	fd := int(os.Stdin.Fd())
+
+	width, height, err := terminal.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "xterm"
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(term, height, width, modes); err != nil {
+		return nil, SessionError{Err: err}
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, SessionError{Err: err}
+	}
+
+	session.Stdin = os.Stdin
+
+	resized := make(chan os.Signal, 1)
+	notifyWindowChanges(resized)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-resized:
+				width, height, err := terminal.GetSize(fd)
+				if err != nil {
+					continue
+				}
+				session.SendRequest("window-change", false, ssh.Marshal(&windowChangeMsg{
+					Columns: uint32(width),
+					Rows:    uint32(height),
+				}))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(resized)
+		close(done)
+		terminal.Restore(fd, state)
+	}, nil
+}
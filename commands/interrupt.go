@@ -0,0 +1,133 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultInterruptGracePeriod = 10 * time.Second
+
+var forwardedSignals = map[os.Signal]ssh.Signal{
+	os.Interrupt:    ssh.SIGINT,
+	syscall.SIGTERM: ssh.SIGTERM,
+}
+
+var signalNumbers = map[ssh.Signal]int{
+	ssh.SIGINT:  2,
+	ssh.SIGTERM: 15,
+}
+
+// InterruptedError is returned when the remote command was interrupted by a
+// local signal. Err holds the outcome of the remote command, if it exited.
+type InterruptedError struct {
+	Signal ssh.Signal
+	Forced bool
+	Err    error
+}
+
+func (e InterruptedError) Error() string {
+	msg := fmt.Sprintf("interrupted by SIG%s", e.Signal)
+	if e.Forced {
+		msg += "; the remote command did not stop in time and the session was closed"
+	} else if e.Err != nil {
+		msg += fmt.Sprintf("; %s", e.Err)
+	}
+
+	return msg
+}
+
+func (e InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// ExitStatus is 128 plus the signal number, as a shell would report.
+func (e InterruptedError) ExitStatus() int {
+	return 128 + signalNumbers[e.Signal]
+}
+
+// runSession starts cmd and waits for it, forwarding interrupts to the remote
+// command. If it is still running gracePeriod after the first interrupt, or a
+// second interrupt arrives, the session is closed.
+func (s *sshClient) runSession(session *ssh.Session, cmd string, interrupts <-chan os.Signal, gracePeriod time.Duration) error {
+	if interrupts == nil {
+		c := make(chan os.Signal, 2)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(c)
+		interrupts = c
+	}
+
+	if gracePeriod == 0 {
+		gracePeriod = defaultInterruptGracePeriod
+	}
+
+	if err := session.Start(cmd); err != nil {
+		return SessionError{Err: err}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	var (
+		interrupted *InterruptedError
+		grace       <-chan time.Time
+	)
+
+	for {
+		select {
+		case err := <-done:
+			if interrupted == nil {
+				return runError(err)
+			}
+			if !interrupted.Forced {
+				interrupted.Err = runError(err)
+			}
+			return *interrupted
+
+		case sig := <-interrupts:
+			if interrupted != nil {
+				s.stderr.Printf("Interrupted again; closing the session\n")
+				interrupted.Forced = true
+				session.Close()
+				continue
+			}
+
+			sshSignal, ok := forwardedSignals[sig]
+			if !ok {
+				sshSignal = ssh.SIGINT
+			}
+
+			s.stderr.Printf("Interrupted; sending SIG%s to the remote command\n", sshSignal)
+			session.Signal(sshSignal)
+			interrupted = &InterruptedError{Signal: sshSignal}
+			grace = time.After(gracePeriod)
+
+		case <-grace:
+			s.stderr.Printf("Remote command still running after %s; closing the session\n", gracePeriod)
+			interrupted.Forced = true
+			grace = nil
+			session.Close()
+		}
+	}
+}
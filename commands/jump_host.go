@@ -0,0 +1,157 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// JumpHost is an intermediate ssh host, like OpenSSH's ProxyJump, that the
+// connection to the Ops Manager VM is tunnelled through. A jump host without
+// credentials of its own uses ssh-agent and the Ops Manager ssh key.
+type JumpHost struct {
+	User        string
+	Host        string
+	Port        int
+	SSHKeyPath  string
+	SSHPassword string
+	AuthMethods []string
+}
+
+// ParseJumpHosts parses a comma separated list of hops, each of the form
+//
+//	[user@]host[:port][?key=<path>&auth=<method>+<method>&password-env=<VAR>]
+//
+// The password itself is read from the named environment variable so that it
+// does not appear on the command line.
+func ParseJumpHosts(spec string) ([]JumpHost, error) {
+	var hops []JumpHost
+	for _, hopSpec := range strings.Split(spec, ",") {
+		hopSpec = strings.TrimSpace(hopSpec)
+		if hopSpec == "" {
+			continue
+		}
+
+		hop, err := parseJumpHost(hopSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host %q: %s", hopSpec, err)
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+func parseJumpHost(spec string) (JumpHost, error) {
+	hop := JumpHost{Port: 22}
+
+	address, options := spec, ""
+	if i := strings.Index(spec, "?"); i != -1 {
+		address, options = spec[:i], spec[i+1:]
+	}
+
+	if i := strings.Index(address, "@"); i != -1 {
+		hop.User, address = address[:i], address[i+1:]
+	}
+
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		hop.Host = host
+		hop.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return JumpHost{}, fmt.Errorf("invalid port %q", port)
+		}
+	} else {
+		hop.Host = strings.Trim(address, "[]")
+	}
+
+	if hop.Host == "" {
+		return JumpHost{}, fmt.Errorf("missing host")
+	}
+
+	for _, option := range strings.Split(options, "&") {
+		if option == "" {
+			continue
+		}
+
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return JumpHost{}, fmt.Errorf("option %q must be of the form name=value", option)
+		}
+
+		switch kv[0] {
+		case "key":
+			hop.SSHKeyPath = kv[1]
+		case "auth":
+			methods, err := ParseAuthMethods(strings.Replace(kv[1], "+", ",", -1))
+			if err != nil {
+				return JumpHost{}, err
+			}
+			hop.AuthMethods = methods
+		case "password-env":
+			hop.SSHPassword = os.Getenv(kv[1])
+			if hop.SSHPassword == "" {
+				return JumpHost{}, fmt.Errorf("environment variable %s is not set", kv[1])
+			}
+		default:
+			return JumpHost{}, fmt.Errorf("unknown option %q", kv[0])
+		}
+	}
+
+	return hop, nil
+}
+
+func (j JumpHost) address() string {
+	port := j.Port
+	if port == 0 {
+		port = 22
+	}
+
+	return net.JoinHostPort(j.Host, strconv.Itoa(port))
+}
+
+// user defaults to the local user name, as OpenSSH does.
+func (j JumpHost) user() string {
+	if j.User != "" {
+		return j.User
+	}
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+func (j JumpHost) auth(input ExecuteOnRemoteInput) sshAuth {
+	if j.SSHKeyPath == "" && j.SSHPassword == "" && len(j.AuthMethods) == 0 {
+		return sshAuth{
+			keyPath:       input.SSHKeyPath,
+			keyPassphrase: input.SSHKeyPassphrase,
+		}
+	}
+
+	return sshAuth{
+		methods:  j.AuthMethods,
+		keyPath:  j.SSHKeyPath,
+		password: j.SSHPassword,
+	}
+}
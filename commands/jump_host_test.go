@@ -0,0 +1,70 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"os"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseJumpHosts", func() {
+	It("parses a chain of hops with their own auth", func() {
+		os.Setenv("BASTION_PASSWORD", "fancy-password")
+		defer os.Unsetenv("BASTION_PASSWORD")
+
+		hops, err := commands.ParseJumpHosts("ubuntu@bastion.example.com:2222?key=/keys/bastion.pem&auth=key+agent,[fd00::5],jump@10.0.0.4?password-env=BASTION_PASSWORD")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hops).To(Equal([]commands.JumpHost{
+			{
+				User:        "ubuntu",
+				Host:        "bastion.example.com",
+				Port:        2222,
+				SSHKeyPath:  "/keys/bastion.pem",
+				AuthMethods: []string{"key", "agent"},
+			},
+			{
+				Host: "fd00::5",
+				Port: 22,
+			},
+			{
+				User:        "jump",
+				Host:        "10.0.0.4",
+				Port:        22,
+				SSHPassword: "fancy-password",
+			},
+		}))
+	})
+
+	It("returns nothing for an empty spec", func() {
+		hops, err := commands.ParseJumpHosts("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hops).To(BeEmpty())
+	})
+
+	It("rejects unknown options", func() {
+		_, err := commands.ParseJumpHosts("bastion.example.com?proxy=socks")
+		Expect(err).To(MatchError(`invalid jump host "bastion.example.com?proxy=socks": unknown option "proxy"`))
+	})
+
+	It("rejects an unset password environment variable", func() {
+		_, err := commands.ParseJumpHosts("bastion.example.com?password-env=NOT_SET_ANYWHERE")
+		Expect(err).To(MatchError(ContainSubstring("environment variable NOT_SET_ANYWHERE is not set")))
+	})
+})
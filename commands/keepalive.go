@@ -0,0 +1,118 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultKeepaliveInterval = 30 * time.Second
+
+// keepalive sends keepalive@openssh.com requests on an interval so that NAT
+// gateways and firewalls do not drop a connection that is idle during a long
+// bosh task. Any reply, even a refusal, shows the server is still there. If
+// there is none for timeout the connection is marked lost and closed, which
+// ends its sessions and returns from ssh.Conn.Wait. Unanswered keepalives
+// are noticed on the next tick, so a dead connection is detected between
+// timeout and timeout plus interval after the last reply.
+type keepalive struct {
+	conn     ssh.Conn
+	interval time.Duration
+	timeout  time.Duration
+
+	lost    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func startKeepalive(conn ssh.Conn, interval, timeout time.Duration) *keepalive {
+	k := &keepalive{
+		conn:     conn,
+		interval: interval,
+		timeout:  timeout,
+		lost:     make(chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go k.run()
+
+	return k
+}
+
+func (k *keepalive) run() {
+	defer close(k.stopped)
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	var (
+		lastSeen = time.Now()
+		inFlight bool
+		replies  = make(chan struct{}, 1)
+	)
+
+	for {
+		select {
+		case <-k.done:
+			return
+
+		case <-replies:
+			inFlight = false
+			lastSeen = time.Now()
+
+		case <-ticker.C:
+			if time.Since(lastSeen) > k.timeout {
+				close(k.lost)
+				k.conn.Close()
+				return
+			}
+
+			if !inFlight {
+				inFlight = true
+				go func() {
+					if _, _, err := k.conn.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+						replies <- struct{}{}
+					}
+				}()
+			}
+		}
+	}
+}
+
+// stop stops sending keepalives.
+func (k *keepalive) stop() {
+	select {
+	case <-k.stopped:
+	default:
+		close(k.done)
+		<-k.stopped
+	}
+}
+
+// expired reports whether the connection has been declared dead. It is set
+// before the connection is closed, so it is already true for anything that
+// fails because of it.
+func (k *keepalive) expired() bool {
+	select {
+	case <-k.lost:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,282 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var hostKeyAlgorithms = []string{
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoED25519, ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+}
+
+// HostKeyMismatchError is returned when the host presents a key that differs
+// from the one recorded in known_hosts or pinned by fingerprint.
+type HostKeyMismatchError struct {
+	Host     string
+	Expected []string
+	Actual   string
+}
+
+func (e HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key verification failed for %s: expected %s but the host presented %s",
+		e.Host, strings.Join(e.Expected, " or "), e.Actual)
+}
+
+// HostKeyVerifier checks host keys against an OpenSSH known_hosts file or a
+// pinned fingerprint.
+type HostKeyVerifier struct {
+	KnownHostsPath  string
+	TrustOnFirstUse bool
+	Fingerprint     string
+	stderr          logger
+}
+
+func NewHostKeyVerifier(knownHostsPath string, trustOnFirstUse bool, fingerprint string, stderr logger) HostKeyVerifier {
+	return HostKeyVerifier{
+		KnownHostsPath:  knownHostsPath,
+		TrustOnFirstUse: trustOnFirstUse,
+		Fingerprint:     fingerprint,
+		stderr:          stderr,
+	}
+}
+
+// Check has the signature of ssh.ClientConfig.HostKeyCallback.
+func (v HostKeyVerifier) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	host := knownhosts.Normalize(hostname)
+
+	if v.Fingerprint != "" {
+		if !fingerprintMatches(v.Fingerprint, key) {
+			return HostKeyMismatchError{Host: host, Expected: []string{v.Fingerprint}, Actual: ssh.FingerprintSHA256(key)}
+		}
+		return nil
+	}
+
+	err := v.check(hostname, remote, key)
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *knownhosts.RevokedError:
+		return fmt.Errorf("host key %s for %s has been revoked", ssh.FingerprintSHA256(key), host)
+	case *knownhosts.KeyError:
+		// Keys of every type count, so that a host cannot get past its
+		// recorded key by offering one of a different algorithm, which trust
+		// on first use would otherwise accept.
+		if len(err.Want) > 0 {
+			var expected []string
+			for _, known := range err.Want {
+				expected = append(expected, ssh.FingerprintSHA256(known.Key))
+			}
+			return HostKeyMismatchError{Host: host, Expected: expected, Actual: ssh.FingerprintSHA256(key)}
+		}
+	default:
+		return err
+	}
+
+	if !v.TrustOnFirstUse {
+		return fmt.Errorf("host key %s %s for %s is not in %s; verify it and add it, pin it with --ssh-host-key-fingerprint, or enable --trust-on-first-use",
+			key.Type(), ssh.FingerprintSHA256(key), host, v.path())
+	}
+
+	if err := v.add(host, key); err != nil {
+		return err
+	}
+	v.stderr.Printf("Permanently added %s %s for %s to %s\n", key.Type(), ssh.FingerprintSHA256(key), host, v.path())
+
+	return nil
+}
+
+// HostKeyAlgorithms orders the supported host key algorithms so the ones
+// already known for address are negotiated first.
+func (v HostKeyVerifier) HostKeyAlgorithms(address string) ([]string, error) {
+	if v.Fingerprint != "" {
+		return nil, nil
+	}
+
+	// A key that is never recorded makes knownhosts report every key it has
+	// for the host.
+	var known []knownhosts.KnownKey
+	err := v.check(address, nil, probeKey{})
+	switch err := err.(type) {
+	case *knownhosts.KeyError:
+		known = err.Want
+	default:
+		return nil, err
+	}
+	if len(known) == 0 {
+		return nil, nil
+	}
+
+	var algorithms []string
+	for _, knownKey := range known {
+		certAuthority, err := isCertAuthority(knownKey)
+		if err != nil {
+			return nil, err
+		}
+		// The defaults negotiate host certificates first.
+		if certAuthority {
+			return nil, nil
+		}
+
+		for _, algorithm := range keyAlgorithms(knownKey.Key) {
+			if !containsString(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+
+	for _, algorithm := range hostKeyAlgorithms {
+		if !containsString(algorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	return algorithms, nil
+}
+
+// keyAlgorithms are the host key algorithms that key can be negotiated with.
+// OpenSSH 8.8 and later refuse ssh-rsa signatures by default, so RSA keys
+// offer the rsa-sha2 ones first.
+func keyAlgorithms(key ssh.PublicKey) []string {
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.KeyAlgoRSA}
+	}
+
+	return []string{key.Type()}
+}
+
+func (v HostKeyVerifier) path() string {
+	if v.KnownHostsPath != "" {
+		return v.KnownHostsPath
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ssh", "known_hosts")
+	}
+
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// check runs key past the known hosts file, treating a missing file as one
+// without entries.
+func (v HostKeyVerifier) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	callback, err := knownhosts.New(v.path())
+	if os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+	if _, ok := err.(*os.PathError); ok {
+		return fmt.Errorf("could not read known hosts file: %s", err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse known hosts file %s: %s", v.path(), err)
+	}
+
+	// knownhosts also matches the remote address, which is unknown when the
+	// host is reached through a jump host.
+	if remote == nil {
+		remote = &net.TCPAddr{}
+	}
+
+	return callback(hostname, remote, key)
+}
+
+func (v HostKeyVerifier) add(host string, key ssh.PublicKey) error {
+	path := v.path()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create known hosts directory: %s", err)
+	}
+
+	existing, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read known hosts file: %s", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open known hosts file: %s", err)
+	}
+	defer file.Close()
+
+	line := knownhosts.Line([]string{host}, key) + "\n"
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		line = "\n" + line
+	}
+
+	if _, err := file.WriteString(line); err != nil {
+		return fmt.Errorf("could not write known hosts file: %s", err)
+	}
+
+	return nil
+}
+
+// isCertAuthority reports whether the known_hosts line of key carries the
+// @cert-authority marker, which knownhosts does not expose.
+func isCertAuthority(key knownhosts.KnownKey) (bool, error) {
+	contents, err := ioutil.ReadFile(key.Filename)
+	if err != nil {
+		return false, fmt.Errorf("could not read known hosts file: %s", err)
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	if key.Line < 1 || key.Line > len(lines) {
+		return false, nil
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(lines[key.Line-1]), "@cert-authority"), nil
+}
+
+// probeKey is a host key that no known_hosts file records.
+type probeKey struct{}
+
+func (probeKey) Type() string { return "probe" }
+
+func (probeKey) Marshal() []byte { return []byte("probe") }
+
+func (probeKey) Verify([]byte, *ssh.Signature) error {
+	return fmt.Errorf("probe keys cannot verify signatures")
+}
+
+func fingerprintMatches(fingerprint string, key ssh.PublicKey) bool {
+	fingerprint = strings.TrimSpace(fingerprint)
+
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return strings.TrimRight(fingerprint, "=") == ssh.FingerprintSHA256(key)
+	}
+
+	return strings.EqualFold(strings.TrimPrefix(fingerprint, "MD5:"), ssh.FingerprintLegacyMD5(key))
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
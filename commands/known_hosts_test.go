@@ -0,0 +1,224 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func newHostKey() ssh.PublicKey {
+	return newHostSigner().PublicKey()
+}
+
+func newHostSigner() ssh.Signer {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	signer, err := ssh.NewSignerFromKey(private)
+	Expect(err).ToNot(HaveOccurred())
+
+	return signer
+}
+
+var _ = Describe("HostKeyVerifier", func() {
+	var (
+		dir            string
+		knownHostsPath string
+		hostKey        ssh.PublicKey
+		stderr         *omfakes.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "known-hosts")
+		Expect(err).ToNot(HaveOccurred())
+
+		knownHostsPath = filepath.Join(dir, "known_hosts")
+		hostKey = newHostKey()
+		stderr = &omfakes.Logger{}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeKnownHosts := func(lines ...string) {
+		var contents string
+		for _, line := range lines {
+			contents += line + "\n"
+		}
+		Expect(ioutil.WriteFile(knownHostsPath, []byte(contents), 0600)).To(Succeed())
+	}
+
+	entry := func(host string, key ssh.PublicKey) string {
+		return fmt.Sprintf("%s %s", host, ssh.MarshalAuthorizedKey(key))
+	}
+
+	It("accepts a key recorded in known_hosts", func() {
+		writeKnownHosts(entry("pcf.example.com", hostKey))
+
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, false, "", stderr)
+		Expect(verifier.Check("pcf.example.com:22", nil, hostKey)).To(Succeed())
+	})
+
+	It("matches non-standard ports and hashed hostnames", func() {
+		writeKnownHosts(entry(knownhosts.HashHostname("[10.0.0.5]:2222"), hostKey))
+
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, false, "", stderr)
+		Expect(verifier.Check("10.0.0.5:2222", nil, hostKey)).To(Succeed())
+		Expect(verifier.Check("10.0.0.5:22", nil, hostKey)).ToNot(Succeed())
+	})
+
+	It("reports the old and new fingerprints when the key has changed", func() {
+		oldKey := newHostKey()
+		writeKnownHosts(entry("pcf.example.com", oldKey))
+
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, true, "", stderr)
+		err := verifier.Check("pcf.example.com:22", nil, hostKey)
+		Expect(err).To(Equal(commands.HostKeyMismatchError{
+			Host:     "pcf.example.com",
+			Expected: []string{ssh.FingerprintSHA256(oldKey)},
+			Actual:   ssh.FingerprintSHA256(hostKey),
+		}))
+		Expect(err.Error()).To(ContainSubstring(ssh.FingerprintSHA256(oldKey)))
+		Expect(err.Error()).To(ContainSubstring(ssh.FingerprintSHA256(hostKey)))
+	})
+
+	It("rejects a key of a different type from the recorded one, even with trust on first use", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+		oldKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+		writeKnownHosts(entry("pcf.example.com", oldKey))
+
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, true, "", stderr)
+		err = verifier.Check("pcf.example.com:22", nil, hostKey)
+		Expect(err).To(Equal(commands.HostKeyMismatchError{
+			Host:     "pcf.example.com",
+			Expected: []string{ssh.FingerprintSHA256(oldKey)},
+			Actual:   ssh.FingerprintSHA256(hostKey),
+		}))
+
+		contents, err := ioutil.ReadFile(knownHostsPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(Equal(entry("pcf.example.com", oldKey) + "\n"))
+		Expect(stderr.PrintfCallCount()).To(Equal(0))
+	})
+
+	It("rejects unknown hosts unless trust on first use is enabled", func() {
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, false, "", stderr)
+		err := verifier.Check("pcf.example.com:22", nil, hostKey)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is not in " + knownHostsPath))
+	})
+
+	It("records unknown hosts when trust on first use is enabled", func() {
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, true, "", stderr)
+		Expect(verifier.Check("pcf.example.com:22", nil, hostKey)).To(Succeed())
+		Expect(stderr.PrintfCallCount()).To(Equal(1))
+
+		contents, err := ioutil.ReadFile(knownHostsPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(Equal(entry("pcf.example.com", hostKey)))
+
+		verifier = commands.NewHostKeyVerifier(knownHostsPath, false, "", stderr)
+		Expect(verifier.Check("pcf.example.com:22", nil, hostKey)).To(Succeed())
+	})
+
+	It("prefers the algorithms of known keys", func() {
+		writeKnownHosts(entry("pcf.example.com", hostKey))
+
+		verifier := commands.NewHostKeyVerifier(knownHostsPath, false, "", stderr)
+		algorithms, err := verifier.HostKeyAlgorithms("pcf.example.com:22")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(algorithms[0]).To(Equal(hostKey.Type()))
+		Expect(algorithms).To(ContainElement(ssh.KeyAlgoRSA))
+	})
+
+	Context("when a certificate authority is recorded for the host", func() {
+		var (
+			ca       ssh.Signer
+			hostCert *ssh.Certificate
+		)
+
+		BeforeEach(func() {
+			ca = newHostSigner()
+			writeKnownHosts("@cert-authority *.example.com " + string(ssh.MarshalAuthorizedKey(ca.PublicKey())))
+
+			hostCert = &ssh.Certificate{
+				Key:             hostKey,
+				CertType:        ssh.HostCert,
+				ValidPrincipals: []string{"pcf.example.com"},
+				ValidBefore:     ssh.CertTimeInfinity,
+			}
+			Expect(hostCert.SignCert(rand.Reader, ca)).To(Succeed())
+		})
+
+		It("accepts host certificates it signed without recording them", func() {
+			verifier := commands.NewHostKeyVerifier(knownHostsPath, true, "", stderr)
+			Expect(verifier.Check("pcf.example.com:22", nil, hostCert)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(knownHostsPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).ToNot(ContainSubstring("pcf.example.com"))
+			Expect(stderr.PrintfCallCount()).To(Equal(0))
+		})
+
+		It("does not trust a plain key on first use", func() {
+			verifier := commands.NewHostKeyVerifier(knownHostsPath, true, "", stderr)
+			err := verifier.Check("pcf.example.com:22", nil, hostKey)
+			Expect(err).To(BeAssignableToTypeOf(commands.HostKeyMismatchError{}))
+			Expect(stderr.PrintfCallCount()).To(Equal(0))
+		})
+
+		It("negotiates host certificates first", func() {
+			verifier := commands.NewHostKeyVerifier(knownHostsPath, false, "", stderr)
+			algorithms, err := verifier.HostKeyAlgorithms("pcf.example.com:22")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(algorithms).To(BeNil())
+		})
+	})
+
+	Context("when a fingerprint is pinned", func() {
+		It("accepts only the pinned key", func() {
+			verifier := commands.NewHostKeyVerifier(knownHostsPath, false, ssh.FingerprintSHA256(hostKey), stderr)
+			Expect(verifier.Check("pcf.example.com:22", nil, hostKey)).To(Succeed())
+
+			err := verifier.Check("pcf.example.com:22", nil, newHostKey())
+			Expect(err).To(BeAssignableToTypeOf(commands.HostKeyMismatchError{}))
+		})
+
+		It("accepts legacy MD5 fingerprints", func() {
+			verifier := commands.NewHostKeyVerifier(knownHostsPath, false, ssh.FingerprintLegacyMD5(hostKey), stderr)
+			Expect(verifier.Check("pcf.example.com:22", nil, hostKey)).To(Succeed())
+		})
+	})
+})
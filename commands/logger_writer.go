@@ -0,0 +1,63 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bytes"
+	"sync"
+)
+
+// loggerWriter adapts a logger to an io.Writer. It logs whole lines so that
+// output split across writes is not broken up by the newlines the logger
+// appends.
+type loggerWriter struct {
+	logger logger
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func newLoggerWriter(l logger) *loggerWriter {
+	return &loggerWriter{logger: l}
+}
+
+func (w *loggerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+
+		w.logger.Printf("%s\n", w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush logs any trailing output that did not end in a newline.
+func (w *loggerWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logger.Printf("%s\n", w.buf)
+		w.buf = nil
+	}
+}
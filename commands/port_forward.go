@@ -0,0 +1,112 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortForward is a local address whose connections are relayed, through the
+// Ops Manager VM, to Target.
+type PortForward struct {
+	Listen string
+	Target string
+}
+
+func (p PortForward) String() string {
+	return p.Listen + " -> " + p.Target
+}
+
+// ParsePortForwards parses a comma separated list of forwards in the form
+// ssh -L takes them:
+//
+//	[bind_address:]port:host:hostport
+//
+// IPv6 addresses must be in square brackets. Forwards without a bind address
+// listen on 127.0.0.1.
+func ParsePortForwards(spec string) ([]PortForward, error) {
+	var forwards []PortForward
+	for _, forwardSpec := range strings.Split(spec, ",") {
+		forwardSpec = strings.TrimSpace(forwardSpec)
+		if forwardSpec == "" {
+			continue
+		}
+
+		forward, err := parsePortForward(forwardSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forward %q: %s", forwardSpec, err)
+		}
+
+		forwards = append(forwards, forward)
+	}
+
+	return forwards, nil
+}
+
+func parsePortForward(spec string) (PortForward, error) {
+	fields := splitForwardSpec(spec)
+
+	bind := "127.0.0.1"
+	switch len(fields) {
+	case 3:
+	case 4:
+		bind, fields = fields[0], fields[1:]
+	default:
+		return PortForward{}, fmt.Errorf("must be of the form [bind_address:]port:host:hostport")
+	}
+
+	for _, port := range []string{fields[0], fields[2]} {
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return PortForward{}, fmt.Errorf("invalid port %q", port)
+		}
+	}
+	if fields[1] == "" {
+		return PortForward{}, fmt.Errorf("missing host")
+	}
+
+	return PortForward{
+		Listen: net.JoinHostPort(bind, fields[0]),
+		Target: net.JoinHostPort(fields[1], fields[2]),
+	}, nil
+}
+
+// splitForwardSpec splits spec on the colons that are not inside square
+// brackets, and strips the brackets.
+func splitForwardSpec(spec string) []string {
+	var (
+		fields    []string
+		field     []rune
+		bracketed bool
+	)
+	for _, r := range spec {
+		switch {
+		case r == '[':
+			bracketed = true
+		case r == ']':
+			bracketed = false
+		case r == ':' && !bracketed:
+			fields = append(fields, string(field))
+			field = nil
+		default:
+			field = append(field, r)
+		}
+	}
+
+	return append(fields, string(field))
+}
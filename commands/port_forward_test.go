@@ -0,0 +1,51 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParsePortForwards", func() {
+	It("parses forwards with and without bind addresses", func() {
+		forwards, err := commands.ParsePortForwards("8844:10.0.0.5:8844, 0.0.0.0:8443:uaa.service.cf.internal:8443,[::1]:3306:[fd00::7]:3306")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forwards).To(Equal([]commands.PortForward{
+			{Listen: "127.0.0.1:8844", Target: "10.0.0.5:8844"},
+			{Listen: "0.0.0.0:8443", Target: "uaa.service.cf.internal:8443"},
+			{Listen: "[::1]:3306", Target: "[fd00::7]:3306"},
+		}))
+	})
+
+	It("returns nothing for an empty spec", func() {
+		forwards, err := commands.ParsePortForwards("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forwards).To(BeEmpty())
+	})
+
+	It("rejects forwards with too few fields", func() {
+		_, err := commands.ParsePortForwards("8844:10.0.0.5")
+		Expect(err).To(MatchError(`invalid forward "8844:10.0.0.5": must be of the form [bind_address:]port:host:hostport`))
+	})
+
+	It("rejects invalid ports", func() {
+		_, err := commands.ParsePortForwards("8844:10.0.0.5:credhub")
+		Expect(err).To(MatchError(`invalid forward "8844:10.0.0.5:credhub": invalid port "credhub"`))
+	})
+})
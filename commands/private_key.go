@@ -0,0 +1,125 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var errIncorrectPassphrase = errors.New("incorrect passphrase")
+
+// passphraseFunc is only called once a key turns out to be encrypted.
+type passphraseFunc func() ([]byte, error)
+
+func loadPrivateKey(path string, passphrase passphraseFunc) (ssh.Signer, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ssh key: %s", err)
+	}
+
+	signer, err := parsePrivateKey(pemBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ssh key %s: %s", path, err)
+	}
+
+	return signer, nil
+}
+
+// keyring holds the private keys a client has loaded, so that each is read,
+// and its passphrase asked for, once however many hops and redials use it.
+type keyring struct {
+	mu      sync.Mutex
+	signers map[string]ssh.Signer
+}
+
+func (k *keyring) load(path string, passphrase passphraseFunc) (ssh.Signer, error) {
+	if k == nil {
+		return loadPrivateKey(path, passphrase)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if signer, ok := k.signers[path]; ok {
+		return signer, nil
+	}
+
+	signer, err := loadPrivateKey(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.signers == nil {
+		k.signers = map[string]ssh.Signer{}
+	}
+	k.signers[path] = signer
+
+	return signer, nil
+}
+
+// promptPassphrase asks for the passphrase of keyPath when it is needed.
+func promptPassphrase(keyPath string) passphraseFunc {
+	return func() ([]byte, error) {
+		return readPassphrase(keyPath)
+	}
+}
+
+// readPassphrase reads the passphrase of keyPath from the terminal without
+// echoing it, provided stdin is a terminal.
+var readPassphrase = func(keyPath string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("key is encrypted; provide --ssh-key-passphrase or --ssh-key-passphrase-file")
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", keyPath)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase: %s", err)
+	}
+
+	return passphrase, nil
+}
+
+// parsePrivateKey parses PEM (PKCS#1, SEC 1, PKCS#8) and OpenSSH-format
+// private keys, decrypting them with passphrase if needed.
+func parsePrivateKey(pemBytes []byte, passphrase passphraseFunc) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+		return signer, err
+	}
+
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, pass)
+	if err == x509.IncorrectPasswordError {
+		return nil, errIncorrectPassphrase
+	}
+
+	return signer, err
+}
@@ -0,0 +1,185 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const maxProductSuggestions = 3
+
+// productSelector picks a deployed product by exactly one of its type, its
+// installation name or its GUID.
+type productSelector struct {
+	Type             string
+	InstallationName string
+	GUID             string
+}
+
+func (s productSelector) empty() bool {
+	return s.Type == "" && s.InstallationName == "" && s.GUID == ""
+}
+
+func (s productSelector) validate() error {
+	given := 0
+	for _, v := range []string{s.Type, s.InstallationName, s.GUID} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return fmt.Errorf("only one of --product-name, --installation-name or --product-guid may be provided")
+	}
+
+	return nil
+}
+
+// field returns the flag the product is selected by, its value, and the same
+// field of product.
+func (s productSelector) field(product Products) (string, string, string) {
+	switch {
+	case s.GUID != "":
+		return "--product-guid", s.GUID, product.Guid
+	case s.InstallationName != "":
+		return "--installation-name", s.InstallationName, product.Name
+	default:
+		return "--product-name", s.Type, product.Type
+	}
+}
+
+// AmbiguousProductError is returned when a product type matches several
+// deployed products, as tiles that can be installed more than once do.
+type AmbiguousProductError struct {
+	Type       string
+	Candidates []Products
+}
+
+func (e AmbiguousProductError) Error() string {
+	var candidates []string
+	for _, p := range e.Candidates {
+		candidates = append(candidates, fmt.Sprintf("%s (guid %s)", p.Name, p.Guid))
+	}
+
+	return fmt.Sprintf("%d deployed products are of type %s; choose one with --installation-name or --product-guid: %s",
+		len(e.Candidates), e.Type, strings.Join(candidates, ", "))
+}
+
+// ProductNotFoundError is returned when no deployed product matches.
+// Suggestions are the closest values of the field that was searched.
+type ProductNotFoundError struct {
+	Flag        string
+	Value       string
+	Suggestions []string
+}
+
+func (e ProductNotFoundError) Error() string {
+	msg := fmt.Sprintf("Could not find product: %s", e.Value)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf("; did you mean %s %s?", e.Flag, strings.Join(e.Suggestions, " or "))
+	}
+
+	return msg
+}
+
+// selectProduct returns the one deployed product that s selects.
+func selectProduct(products []Products, s productSelector) (Products, error) {
+	var matches []Products
+	for _, p := range products {
+		if _, want, got := s.field(p); got == want {
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		flag, value, _ := s.field(Products{})
+		return Products{}, ProductNotFoundError{Flag: flag, Value: value, Suggestions: suggestProducts(products, s)}
+	default:
+		return Products{}, AmbiguousProductError{Type: s.Type, Candidates: matches}
+	}
+}
+
+// suggestProducts returns up to maxProductSuggestions values of the selected
+// field that are close to the one asked for, closest first.
+func suggestProducts(products []Products, s productSelector) []string {
+	type suggestion struct {
+		value    string
+		distance int
+	}
+
+	seen := map[string]bool{}
+	var suggestions []suggestion
+	for _, p := range products {
+		_, want, got := s.field(p)
+		if got == "" || seen[got] {
+			continue
+		}
+		seen[got] = true
+
+		distance := editDistance(strings.ToLower(want), strings.ToLower(got))
+		if distance <= len(want)/3+1 || strings.Contains(got, want) || strings.Contains(want, got) {
+			suggestions = append(suggestions, suggestion{value: got, distance: distance})
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	var values []string
+	for i := 0; i < len(suggestions) && i < maxProductSuggestions; i++ {
+		values = append(values, suggestions[i].value)
+	}
+
+	return values
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,110 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pivotal-cf/om/commands"
+	"github.com/pivotal-cf/om/flags"
+)
+
+type DeployedProducts struct {
+	requestService requestService
+	stdout         logger
+	Options        struct {
+		Format string `short:"f" long:"format" description:"output format: table or json" default:"table"`
+	}
+}
+
+// deployedProduct is a deployed product as the products command lists it.
+type deployedProduct struct {
+	Type             string `json:"type"`
+	InstallationName string `json:"installation_name"`
+	GUID             string `json:"guid"`
+	Version          string `json:"product_version"`
+	Deployment       string `json:"deployment_name"`
+}
+
+func NewDeployedProductsCommand(rs requestService, stdout logger) DeployedProducts {
+	return DeployedProducts{requestService: rs, stdout: stdout}
+}
+
+func (d DeployedProducts) Usage() commands.Usage {
+	return commands.Usage{
+		Description:      "Lists the deployed products with their installation names, GUIDs, versions and bosh deployments",
+		ShortDescription: "Lists the deployed products",
+		Flags:            d.Options,
+	}
+}
+
+func (d DeployedProducts) Execute(args []string) error {
+	_, err := flags.Parse(&d.Options, args)
+	if err != nil {
+		return fmt.Errorf("could not parse products flags: %s", err)
+	}
+
+	if d.Options.Format != "table" && d.Options.Format != "json" {
+		return fmt.Errorf("unknown format %q: expected table or json", d.Options.Format)
+	}
+
+	var products []Products
+	if err := getJSON(d.requestService, "/api/v0/deployed/products/", &products); err != nil {
+		return err
+	}
+
+	deployed := []deployedProduct{}
+	for _, p := range products {
+		deployed = append(deployed, deployedProduct{
+			Type:             p.Type,
+			InstallationName: p.Name,
+			GUID:             p.Guid,
+			Version:          p.Version,
+			Deployment:       p.deploymentName(),
+		})
+	}
+
+	if d.Options.Format == "json" {
+		output, err := json.MarshalIndent(deployed, "", "  ")
+		if err != nil {
+			return err
+		}
+		d.stdout.Printf("%s\n", output)
+		return nil
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tINSTALLATION NAME\tGUID\tVERSION\tDEPLOYMENT")
+	for _, p := range deployed {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Type, p.InstallationName, p.GUID, p.Version, p.Deployment)
+	}
+	w.Flush()
+
+	// tabwriter pads the cell before an empty deployment.
+	var trimmed bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n") {
+		fmt.Fprintf(&trimmed, "%s\n", strings.TrimRight(line, " "))
+	}
+
+	d.stdout.Printf("%s", trimmed.String())
+
+	return nil
+}
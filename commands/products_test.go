@@ -0,0 +1,121 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	"github.com/pivotal-cf/om/api"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DeployedProducts", func() {
+	Describe("Execute", func() {
+		var (
+			command        commands.DeployedProducts
+			requestService *omfakes.RequestService
+			stdout         *omfakes.Logger
+		)
+
+		BeforeEach(func() {
+			requestService = &omfakes.RequestService{}
+			requestService.InvokeReturns(api.RequestServiceInvokeOutput{
+				StatusCode: http.StatusOK,
+				Body: strings.NewReader(`[
+					{"installation_name": "p-bosh", "guid": "p-bosh-7c5e3a1f", "type": "p-bosh", "product_version": "2.1-build.212"},
+					{"installation_name": "cf-9b1d2e4a", "guid": "cf-9b1d2e4a", "type": "cf", "product_version": "2.1.3"}
+				]`),
+			}, nil)
+			stdout = &omfakes.Logger{}
+
+			command = commands.NewDeployedProductsCommand(requestService, stdout)
+		})
+
+		output := func() string {
+			Expect(stdout.PrintfCallCount()).To(Equal(1))
+			format, v := stdout.PrintfArgsForCall(0)
+			return fmt.Sprintf(format, v...)
+		}
+
+		It("prints a table of the deployed products", func() {
+			Expect(command.Execute([]string{})).To(Succeed())
+
+			Expect(output()).To(Equal(
+				"TYPE    INSTALLATION NAME  GUID             VERSION        DEPLOYMENT\n" +
+					"p-bosh  p-bosh             p-bosh-7c5e3a1f  2.1-build.212\n" +
+					"cf      cf-9b1d2e4a        cf-9b1d2e4a      2.1.3          cf-9b1d2e4a\n",
+			))
+
+			input := requestService.InvokeArgsForCall(0)
+			Expect(input.Path).To(Equal("/api/v0/deployed/products/"))
+			Expect(input.Method).To(Equal("GET"))
+		})
+
+		It("prints json", func() {
+			Expect(command.Execute([]string{"--format", "json"})).To(Succeed())
+
+			var products []map[string]string
+			Expect(json.Unmarshal([]byte(output()), &products)).To(Succeed())
+			Expect(products).To(HaveLen(2))
+			Expect(products[1]).To(Equal(map[string]string{
+				"type":              "cf",
+				"installation_name": "cf-9b1d2e4a",
+				"guid":              "cf-9b1d2e4a",
+				"product_version":   "2.1.3",
+				"deployment_name":   "cf-9b1d2e4a",
+			}))
+		})
+
+		It("leaves the deployment of the director blank", func() {
+			Expect(command.Execute([]string{"--format", "json"})).To(Succeed())
+
+			var products []map[string]string
+			Expect(json.Unmarshal([]byte(output()), &products)).To(Succeed())
+			Expect(products[0]["type"]).To(Equal("p-bosh"))
+			Expect(products[0]["deployment_name"]).To(BeEmpty())
+		})
+
+		It("prints an empty json list when nothing is deployed", func() {
+			requestService.InvokeReturns(api.RequestServiceInvokeOutput{StatusCode: http.StatusOK, Body: strings.NewReader(`[]`)}, nil)
+
+			Expect(command.Execute([]string{"-f", "json"})).To(Succeed())
+			Expect(output()).To(Equal("[]\n"))
+		})
+
+		It("rejects unknown formats", func() {
+			err := command.Execute([]string{"--format", "yaml"})
+			Expect(err).To(MatchError(`unknown format "yaml": expected table or json`))
+			Expect(requestService.InvokeCallCount()).To(Equal(0))
+		})
+
+		It("returns API errors", func() {
+			requestService.InvokeReturns(api.RequestServiceInvokeOutput{
+				StatusCode: http.StatusUnauthorized,
+				Body:       strings.NewReader(`{"errors": ["Bad credentials"]}`),
+			}, nil)
+
+			err := command.Execute([]string{})
+			Expect(err).To(BeAssignableToTypeOf(commands.UnauthorizedError{}))
+		})
+	})
+})
@@ -0,0 +1,114 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeEnvFileCmd creates a file only the ssh user can read and copies stdin
+// into it, so that the environment never appears in a command line.
+const writeEnvFileCmd = `umask 077 && f=$(mktemp "${TMPDIR:-/tmp}/execute-on-opsman-env.XXXXXX") && cat > "$f" && echo "$f"`
+
+type envVar struct {
+	name  string
+	value string
+}
+
+func parseEnv(env []string) ([]envVar, error) {
+	var vars []envVar
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || !envName.MatchString(kv[0]) {
+			return nil, fmt.Errorf("invalid environment variable %q, expected NAME=value", e)
+		}
+		vars = append(vars, envVar{name: kv[0], value: kv[1]})
+	}
+
+	return vars, nil
+}
+
+// sendEnv sets env for the command that will be run in session, returning the
+// command to run and a function that cleans up after it. Variables are sent
+// with setenv requests where sshd accepts them. As sshd only accepts the
+// names listed in its AcceptEnv, the usual fallback is a temporary file that
+// the command sources and removes before it runs.
+func (s *sshClient) sendEnv(client *ssh.Client, session *ssh.Session, env []string, cmd string) (string, func(), error) {
+	vars, err := parseEnv(env)
+	if err != nil {
+		return "", nil, err
+	}
+
+	accepted := true
+	for _, v := range vars {
+		if err := session.Setenv(v.name, v.value); err != nil {
+			accepted = false
+			break
+		}
+	}
+	if accepted {
+		return cmd, func() {}, nil
+	}
+
+	path, err := writeEnvFile(client, vars)
+	if err != nil {
+		return "", nil, SessionError{Err: fmt.Errorf("could not write remote environment file: %s", err)}
+	}
+
+	// The command removes the file as soon as it has been read, but it may
+	// never get to run.
+	cleanup := func() {
+		if session, err := client.NewSession(); err == nil {
+			session.Run("rm -f " + shellQuote(path))
+			session.Close()
+		}
+	}
+
+	return fmt.Sprintf(". %s || exit 1; rm -f %s; %s", shellQuote(path), shellQuote(path), cmd), cleanup, nil
+}
+
+func writeEnvFile(client *ssh.Client, vars []envVar) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var contents bytes.Buffer
+	for _, v := range vars {
+		fmt.Fprintf(&contents, "export %s=%s\n", v.name, shellQuote(v.value))
+	}
+	session.Stdin = strings.NewReader(contents.String())
+
+	output, err := session.Output(writeEnvFileCmd)
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSpace(string(output))
+	if path == "" {
+		return "", fmt.Errorf("no file name was returned")
+	}
+
+	return path, nil
+}
@@ -0,0 +1,176 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultRetryDelay     = time.Second
+	maxRetryDelay         = 30 * time.Second
+)
+
+// DefaultRetryableErrors are the connection errors, matched as case
+// insensitive substrings, that are retried when none are configured.
+// "unexpected message type 3" is sent by sshd while the Ops Manager VM is
+// still booting.
+var DefaultRetryableErrors = []string{
+	"unexpected message type 3",
+	"connection refused",
+	"connection reset by peer",
+	"i/o timeout",
+	"EOF",
+}
+
+var (
+	errHandshakeTimeout = errors.New("ssh handshake: i/o timeout")
+	errDialTimeout      = errors.New("dial tcp: i/o timeout")
+)
+
+// retryPolicy decides whether, and after how long, a failed connection
+// attempt is retried.
+type retryPolicy struct {
+	retries   int
+	delay     time.Duration
+	retryable []string
+}
+
+func newRetryPolicy(input ExecuteOnRemoteInput) retryPolicy {
+	p := retryPolicy{
+		retries:   input.Retries,
+		delay:     input.RetryDelay,
+		retryable: input.RetryableErrors,
+	}
+
+	if p.delay == 0 {
+		p.delay = defaultRetryDelay
+	}
+	if len(p.retryable) == 0 {
+		p.retryable = DefaultRetryableErrors
+	}
+
+	return p
+}
+
+// shouldRetry reports whether err is transient. Authentication and host key
+// failures never are. Errors are matched regardless of case, as OpenSSH jump
+// hosts report "Connection refused" where Go reports "connection refused".
+func (p retryPolicy) shouldRetry(err error) bool {
+	dialErr, ok := err.(DialError)
+	if !ok {
+		return false
+	}
+	if _, ok := dialErr.Err.(HostKeyMismatchError); ok {
+		return false
+	}
+
+	message := strings.ToLower(dialErr.Err.Error())
+	for _, s := range p.retryable {
+		if strings.Contains(message, strings.ToLower(s)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff is the delay before the given retry, doubling from the initial
+// delay up to a maximum, with up to half of it randomised so that many
+// clients do not retry in lockstep.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.delay
+	for i := 1; i < retry && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// ParseRetryableErrors splits a comma separated list of error substrings.
+func ParseRetryableErrors(list string) []string {
+	var retryable []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			retryable = append(retryable, s)
+		}
+	}
+
+	return retryable
+}
+
+// handshake runs fn, closing conn if it has not returned within timeout so
+// that a server that accepts connections but never responds cannot hang it.
+func handshake(conn net.Conn, timeout time.Duration, fn func() error) error {
+	timedOut := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		conn.Close()
+		close(timedOut)
+	})
+
+	err := fn()
+	if !timer.Stop() {
+		<-timedOut
+		return errHandshakeTimeout
+	}
+
+	return err
+}
+
+// dialVia opens a TCP connection to address, through via when it is not nil,
+// giving up after timeout. ssh.Client.Dial takes no timeout, and a jump host
+// that never answers the channel open would otherwise hang it.
+func dialVia(via *ssh.Client, address string, timeout time.Duration) (net.Conn, error) {
+	if via == nil {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := via.Dial("tcp", address)
+		done <- result{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, errDialTimeout
+	}
+}
@@ -0,0 +1,140 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// The parts of RFC 1928 that a local tunnel needs: no authentication and
+// CONNECT requests only.
+const (
+	socks5Version          = 5
+	socks5NoAuth           = 0
+	socks5NoAcceptable     = 0xff
+	socks5Connect          = 1
+	socks5IPv4             = 1
+	socks5DomainName       = 3
+	socks5IPv6             = 4
+	socks5Succeeded        = 0
+	socks5Failure          = 1
+	socks5CmdNotSupported  = 7
+	socks5AddrNotSupported = 8
+)
+
+// socks5Handshake negotiates a SOCKS5 CONNECT request on conn and returns
+// the host:port the client asked for. The client must then be told whether
+// the connection succeeded with socks5Reply.
+func socks5Handshake(conn io.ReadWriter) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	noAuth := false
+	for _, method := range methods {
+		if method == socks5NoAuth {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return "", errors.New("the SOCKS client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socks5Connect {
+		socks5Reply(conn, socks5CmdNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socks5IPv4, socks5IPv6:
+		size := net.IPv4len
+		if request[3] == socks5IPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5DomainName:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return "", err
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socks5Reply(conn, socks5AddrNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// socks5Reply answers a CONNECT request. The bound address is left empty, as
+// the connection is made from the Ops Manager VM rather than from here.
+func socks5Reply(conn io.Writer, status byte) error {
+	_, err := conn.Write([]byte{socks5Version, status, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// pipe copies data between a and b until either side is done, then closes
+// both.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copy := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go copy(a, b)
+	go copy(b, a)
+	<-done
+
+	a.Close()
+	b.Close()
+	<-done
+}
@@ -0,0 +1,183 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	AuthMethodAgent    = "agent"
+	AuthMethodKey      = "key"
+	AuthMethodPassword = "password"
+)
+
+// DefaultAuthMethods is the order in which auth methods are tried when none
+// are requested explicitly. Methods without credentials are skipped.
+var DefaultAuthMethods = []string{AuthMethodAgent, AuthMethodKey, AuthMethodPassword}
+
+type sshAuth struct {
+	methods       []string
+	keyPath       string
+	keyPassphrase string
+	password      string
+
+	// keys caches the parsed key across the hosts and redials of a client.
+	keys *keyring
+}
+
+// ParseAuthMethods splits a comma separated list such as "agent,key".
+func ParseAuthMethods(list string) ([]string, error) {
+	var methods []string
+	for _, method := range strings.Split(list, ",") {
+		method = strings.TrimSpace(method)
+		switch method {
+		case "":
+			continue
+		case AuthMethodAgent, AuthMethodKey, AuthMethodPassword:
+			methods = append(methods, method)
+		default:
+			return nil, fmt.Errorf("unknown ssh auth method %q, expected one of: %s", method, strings.Join(DefaultAuthMethods, ", "))
+		}
+	}
+
+	return methods, nil
+}
+
+// build returns the ssh.AuthMethods to try, in order, and a function that
+// releases the ssh-agent connection. Agent and key file signers share a single
+// publickey method because the ssh package only attempts the first instance
+// of each RFC 4252 method. An unreachable ssh-agent is only an error when
+// agent auth was requested; otherwise it is reported to stderr and skipped.
+func (a sshAuth) build(stderr logger) ([]ssh.AuthMethod, func(), error) {
+	methods := a.methods
+	explicit := len(methods) > 0
+	if !explicit {
+		methods = DefaultAuthMethods
+	}
+
+	var (
+		auths     []ssh.AuthMethod
+		signers   []func() ([]ssh.Signer, error)
+		publicKey = -1
+		closers   []func()
+	)
+
+	cleanup := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	for _, method := range methods {
+		switch method {
+		case AuthMethodAgent:
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				if explicit {
+					cleanup()
+					return nil, nil, fmt.Errorf("ssh-agent auth requested but SSH_AUTH_SOCK is not set")
+				}
+				continue
+			}
+
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				if explicit {
+					cleanup()
+					return nil, nil, fmt.Errorf("could not connect to ssh-agent: %s", err)
+				}
+				stderr.Printf("Skipping ssh-agent auth, could not connect to ssh-agent: %s\n", err)
+				continue
+			}
+			closers = append(closers, func() { conn.Close() })
+
+			signers = append(signers, agent.NewClient(conn).Signers)
+
+		case AuthMethodKey:
+			if a.keyPath == "" {
+				if explicit {
+					cleanup()
+					return nil, nil, fmt.Errorf("key auth requested but no ssh key path was provided")
+				}
+				continue
+			}
+
+			passphrase := promptPassphrase(a.keyPath)
+			if a.keyPassphrase != "" {
+				passphrase = func() ([]byte, error) { return []byte(a.keyPassphrase), nil }
+			}
+
+			signer, err := a.keys.load(a.keyPath, passphrase)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+
+			signers = append(signers, func() ([]ssh.Signer, error) {
+				return []ssh.Signer{signer}, nil
+			})
+
+		case AuthMethodPassword:
+			if a.password == "" {
+				if explicit {
+					cleanup()
+					return nil, nil, fmt.Errorf("password auth requested but no ssh password was provided")
+				}
+				continue
+			}
+
+			auths = append(auths, ssh.Password(a.password))
+			continue
+
+		default:
+			cleanup()
+			return nil, nil, fmt.Errorf("unknown ssh auth method %q", method)
+		}
+
+		if publicKey == -1 {
+			publicKey = len(auths)
+			auths = append(auths, nil)
+		}
+	}
+
+	if publicKey != -1 {
+		auths[publicKey] = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var all []ssh.Signer
+			for _, getSigners := range signers {
+				s, err := getSigners()
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, s...)
+			}
+			return all, nil
+		})
+	}
+
+	if len(auths) == 0 {
+		cleanup()
+		return nil, nil, fmt.Errorf("no ssh credentials available: provide an ssh key, a password or a running ssh-agent")
+	}
+
+	return auths, cleanup, nil
+}
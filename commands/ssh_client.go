@@ -16,11 +16,12 @@
 package commands
 
 import (
-	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -28,74 +29,417 @@ import (
 // go:generate counterfeiter -o ./fakes/ssh_client.go --fake-name SSHClient . SSHClient
 type SSHClient interface {
 	ExecuteOnRemote(input ExecuteOnRemoteInput) error
+	CaptureOnRemote(input ExecuteOnRemoteInput) (ExecuteOnRemoteOutput, error)
+	Connect(input ExecuteOnRemoteInput) (SSHConnection, error)
+}
+
+// SSHConnection is an open connection to the Ops Manager VM, for running
+// several commands without connecting for each of them. Dial opens
+// connections from the VM to other hosts, and Wait blocks until the
+// connection is closed.
+//
+// go:generate counterfeiter -o ./fakes/ssh_connection.go --fake-name SSHConnection . SSHConnection
+type SSHConnection interface {
+	ExecuteOnRemote(input ExecuteOnRemoteInput) error
+	CaptureOnRemote(input ExecuteOnRemoteInput) (ExecuteOnRemoteOutput, error)
+	Dial(network, address string) (net.Conn, error)
+	Wait() error
+	Close() error
 }
 
 type ExecuteOnRemoteInput struct {
-	Host        string
-	SSHKeyPath  string
-	SSHPassword string
-	Env         []string
-	Command     []string
+	Host               string
+	Port               int
+	User               string
+	SSHKeyPath         string
+	SSHKeyPassphrase   string
+	SSHPassword        string
+	AuthMethods        []string
+	KnownHostsPath     string
+	TrustOnFirstUse    bool
+	HostKeyFingerprint string
+	JumpHosts          []JumpHost
+
+	// Command is the argv of the remote command. Each argument is quoted so
+	// that the remote shell passes it on unchanged. RawCommand is appended
+	// to it as it is, for the remote shell to interpret.
+	Command    []string
+	RawCommand string
+
+	// Env holds NAME=value pairs to set for the command. They are never put
+	// on the command line, where they would be visible in the remote process
+	// list.
+	Env []string
+
+	// ConnectTimeout bounds the TCP connection and ssh handshake with each
+	// host. Failed connections are retried Retries times, with exponential
+	// backoff from RetryDelay, when the error contains one of RetryableErrors.
+	ConnectTimeout  time.Duration
+	Retries         int
+	RetryDelay      time.Duration
+	RetryableErrors []string
+
+	// KeepaliveInterval is how often keepalives are sent for as long as the
+	// connection is open; a negative interval disables them. The connection is declared
+	// lost if none are answered for KeepaliveTimeout, which defaults to three
+	// intervals.
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
+
+	// Interactive runs the command in a pty attached to the local terminal.
+	Interactive bool
+
+	// Interrupts are forwarded to the remote command. When nil, SIGINT and
+	// SIGTERM received by this process are used. The session is closed if
+	// the command has not exited InterruptGracePeriod after an interrupt.
+	Interrupts           <-chan os.Signal
+	InterruptGracePeriod time.Duration
+
+	// Stdout and Stderr receive the remote command's output streams. They
+	// default to the loggers the client was created with, or to the local
+	// terminal in interactive mode. Stdin, when set, is sent to the remote
+	// command's input.
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+
+	// CaptureLimit caps the bytes of each stream that CaptureOnRemote keeps;
+	// 0 keeps everything. With Tee, CaptureOnRemote also streams the output
+	// as ExecuteOnRemote would.
+	CaptureLimit int
+	Tee          bool
 }
 
 type sshClient struct {
 	stderr logger
 	stdout logger
+	keys   *keyring
+}
+
+// sshConnection is a client for the Ops Manager VM together with the jump
+// host clients it is tunnelled through.
+type sshConnection struct {
+	*ssh.Client
+	address   string
+	hops      []*ssh.Client
+	sshClient *sshClient
+
+	keepalive        *keepalive
+	keepaliveTimeout time.Duration
 }
 
 func NewSSHClient(stdout, stderr logger) SSHClient {
-	return &sshClient{stdout: stdout, stderr: stderr}
+	return &sshClient{stdout: stdout, stderr: stderr, keys: &keyring{}}
 }
 
 func (s *sshClient) ExecuteOnRemote(input ExecuteOnRemoteInput) error {
-	var auths []ssh.AuthMethod
+	if input.Interactive {
+		if err := checkInteractive(); err != nil {
+			return err
+		}
+	}
+
+	conn, err := s.connect(input)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.ExecuteOnRemote(input)
+}
 
-	if input.SSHPassword != "" {
-		auths = []ssh.AuthMethod{ssh.Password(input.SSHPassword)}
-	} else {
-		pemBytes, err := ioutil.ReadFile(input.SSHKeyPath)
+// Connect opens a connection that any number of commands can be run over,
+// each in a session of its own.
+func (s *sshClient) Connect(input ExecuteOnRemoteInput) (SSHConnection, error) {
+	conn, err := s.connect(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// ExecuteOnRemote runs input.Command in a new session. The connection
+// settings in input are ignored.
+func (c *sshConnection) ExecuteOnRemote(input ExecuteOnRemoteInput) error {
+	if input.Interactive {
+		if err := checkInteractive(); err != nil {
+			return err
+		}
+	}
+
+	session, err := c.NewSession()
+	if err != nil {
+		return SessionError{Err: err}
+	}
+	defer session.Close()
+
+	fullcmd := shellJoin(input.Command)
+	if input.RawCommand != "" {
+		fullcmd = strings.TrimSpace(fullcmd + " " + input.RawCommand)
+	}
+	if len(input.Env) > 0 {
+		var cleanup func()
+		fullcmd, cleanup, err = c.sshClient.sendEnv(c.Client, session, input.Env, fullcmd)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	if input.Interactive {
+		restore, err := startInteractive(session)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		defer restore()
+
+		// Prompts do not end in a newline, so the line-buffered loggers
+		// would hold them back.
+		session.Stdout, session.Stderr = os.Stdout, os.Stderr
+	}
+
+	if input.Stdin != nil {
+		session.Stdin = input.Stdin
+	}
+
+	if input.Stdout != nil {
+		session.Stdout = input.Stdout
+	}
+	if session.Stdout == nil {
+		w := newLoggerWriter(c.sshClient.stdout)
+		defer w.Flush()
+		session.Stdout = w
+	}
+
+	if input.Stderr != nil {
+		session.Stderr = input.Stderr
+	}
+	if session.Stderr == nil {
+		w := newLoggerWriter(c.sshClient.stderr)
+		defer w.Flush()
+		session.Stderr = w
+	}
+
+	err = c.sshClient.runSession(session, fullcmd, input.Interrupts, input.InterruptGracePeriod)
+	if c.lost() {
+		return c.lostError()
+	}
+
+	return err
+}
+
+// Wait blocks until the connection is closed, returning a
+// ConnectionLostError if that was because keepalives went unanswered.
+func (c *sshConnection) Wait() error {
+	err := c.Client.Wait()
+	if c.lost() {
+		return c.lostError()
+	}
+
+	return err
+}
+
+// startKeepalive sends keepalives for as long as the connection is open,
+// unless input disables them.
+func (c *sshConnection) startKeepalive(input ExecuteOnRemoteInput) {
+	if input.KeepaliveInterval < 0 {
+		return
+	}
+
+	interval := input.KeepaliveInterval
+	if interval == 0 {
+		interval = defaultKeepaliveInterval
+	}
+	timeout := input.KeepaliveTimeout
+	if timeout == 0 {
+		timeout = 3 * interval
+	}
+
+	c.keepalive = startKeepalive(c.Client, interval, timeout)
+	c.keepaliveTimeout = timeout
+}
+
+func (c *sshConnection) lost() bool {
+	return c.keepalive != nil && c.keepalive.expired()
+}
+
+func (c *sshConnection) lostError() error {
+	return ConnectionLostError{Address: c.address, Timeout: c.keepaliveTimeout}
+}
+
+// runError converts the error from ssh.Session.Run into a RemoteExitError or
+// SessionError.
+func runError(err error) error {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *ssh.ExitError:
+		return RemoteExitError{
+			ExitStatus: err.ExitStatus(),
+			Signal:     err.Signal(),
+			Message:    err.Msg(),
 		}
+	default:
+		return SessionError{Err: err}
+	}
+}
 
-		signer, err := ssh.ParsePrivateKey(pemBytes)
+// connect dials each jump host in turn and then the Ops Manager VM through the
+// last of them.
+func (s *sshClient) connect(input ExecuteOnRemoteInput) (*sshConnection, error) {
+	verifier := NewHostKeyVerifier(input.KnownHostsPath, input.TrustOnFirstUse, "", s.stderr)
+
+	timeout := input.ConnectTimeout
+	if timeout == 0 {
+		timeout = defaultConnectTimeout
+	}
+	retry := newRetryPolicy(input)
+
+	conn := &sshConnection{sshClient: s}
+	for _, hop := range input.JumpHosts {
+		client, err := s.dial(conn.Client, hop.address(), hop.user(), hop.auth(input), verifier, timeout, retry)
 		if err != nil {
-			log.Fatal(err)
+			conn.Close()
+			return nil, err
+		}
+
+		if conn.Client != nil {
+			conn.hops = append(conn.hops, conn.Client)
 		}
+		conn.Client = client
+	}
+
+	port := input.Port
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(input.Host, strconv.Itoa(port))
 
-		auths = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	user := input.User
+	if user == "" {
+		user = "ubuntu"
+	}
+
+	verifier.Fingerprint = input.HostKeyFingerprint
+
+	client, err := s.dial(conn.Client, address, user, sshAuth{
+		methods:       input.AuthMethods,
+		keyPath:       input.SSHKeyPath,
+		keyPassphrase: input.SSHKeyPassphrase,
+		password:      input.SSHPassword,
+	}, verifier, timeout, retry)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
+	if conn.Client != nil {
+		conn.hops = append(conn.hops, conn.Client)
+	}
+	conn.Client = client
+	conn.address = address
+	conn.startKeepalive(input)
+
+	return conn, nil
+}
+
+// dial opens an ssh connection to address, tunnelled through via when it is
+// not nil, retrying transient failures.
+func (s *sshClient) dial(via *ssh.Client, address, user string, auth sshAuth, verifier HostKeyVerifier, timeout time.Duration, retry retryPolicy) (*ssh.Client, error) {
+	auth.keys = s.keys
+	auths, closeAuth, err := auth.build(s.stderr)
+	if err != nil {
+		return nil, AuthError{Address: address, User: user, Err: err}
+	}
+	defer closeAuth()
+
+	hostKeyAlgorithms, err := verifier.HostKeyAlgorithms(address)
+	if err != nil {
+		return nil, DialError{Address: address, Err: err}
+	}
+
+	// The ssh package flattens host key errors into a string, so keep the
+	// original to report it intact.
+	var hostKeyErr error
 	cfg := &ssh.ClientConfig{
-		User: "ubuntu",
+		User: user,
 		Auth: auths,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyErr = verifier.Check(hostname, remote, key)
+			return hostKeyErr
+		},
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           timeout,
 	}
 	cfg.SetDefaults()
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", input.Host), cfg)
-	for connErr := err; connErr != nil; {
-		if strings.Contains(connErr.Error(), "unexpected message type 3") {
-			s.stderr.Printf("Failed to establish connection; retrying\n")
-			client, connErr = ssh.Dial("tcp", fmt.Sprintf("%s:22", input.Host), cfg)
-		} else {
-			log.Fatal(connErr)
+	dial := func() (*ssh.Client, error) {
+		conn, err := dialVia(via, address, cfg.Timeout)
+		if err != nil {
+			return nil, DialError{Address: address, Err: err}
 		}
+
+		var (
+			c     ssh.Conn
+			chans <-chan ssh.NewChannel
+			reqs  <-chan *ssh.Request
+		)
+		err = handshake(conn, timeout, func() error {
+			var err error
+			c, chans, reqs, err = ssh.NewClientConn(conn, address, cfg)
+			return err
+		})
+		if err != nil {
+			conn.Close()
+
+			switch {
+			case hostKeyErr != nil:
+				return nil, DialError{Address: address, Err: hostKeyErr}
+			case strings.Contains(err.Error(), "unable to authenticate"):
+				return nil, AuthError{Address: address, User: user, Err: err}
+			default:
+				return nil, DialError{Address: address, Err: err}
+			}
+		}
+
+		return ssh.NewClient(c, chans, reqs), nil
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
-	if err != nil {
-		log.Fatal(err)
+	for attempt := 1; ; attempt++ {
+		client, err := dial()
+		if err == nil {
+			return client, nil
+		}
+
+		if attempt > retry.retries || !retry.shouldRetry(err) {
+			if attempt > 1 {
+				s.stderr.Printf("Attempt %d of %d to connect to %s failed: %s; giving up\n", attempt, retry.retries+1, address, err)
+			}
+			return nil, err
+		}
+
+		delay := retry.backoff(attempt)
+		s.stderr.Printf("Attempt %d of %d to connect to %s failed: %s; retrying in %s\n", attempt, retry.retries+1, address, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// Close closes the connection to the Ops Manager VM and then each jump host,
+// innermost first.
+func (c *sshConnection) Close() error {
+	if c.keepalive != nil {
+		c.keepalive.stop()
 	}
-	defer session.Close()
 
-	fullcmd := strings.Join(append(input.Env, strings.Join(input.Command, " ")), " ")
+	var err error
+	if c.Client != nil {
+		err = c.Client.Close()
+	}
 
-	session.Stdout = os.Stdout
-	err = session.Run(fullcmd)
-	if err != nil {
-		log.Fatalf("Run failed:%v", err)
+	for i := len(c.hops) - 1; i >= 0; i-- {
+		c.hops[i].Close()
 	}
 
-	return nil
+	return err
 }
@@ -0,0 +1,1037 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// testSSHServer is an in-process ssh server that accepts the password
+// "secret" and any authorized keys, runs exec requests through handler and passes the signals it
+// receives to signals.
+type testSSHServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+	handler  func(command string, channel ssh.Channel) uint32
+	signals  chan string
+
+	mu           sync.Mutex
+	users        []string
+	commands     []string
+	keepalives   int
+	unresponsive bool
+	stallForward bool
+	acceptEnv    bool
+	env          []string
+	authorized   []ssh.PublicKey
+}
+
+func newTestSSHServer() *testSSHServer {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	hostKey, err := ssh.NewSignerFromKey(private)
+	Expect(err).ToNot(HaveOccurred())
+
+	return newTestSSHServerWithHostKey(hostKey)
+}
+
+func newTestSSHServerWithHostKey(hostKey ssh.Signer) *testSSHServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	server := &testSSHServer{
+		listener: listener,
+		hostKey:  hostKey,
+		handler: func(string, ssh.Channel) uint32 {
+			return 0
+		},
+		signals: make(chan string, 10),
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			for _, authorized := range server.authorized {
+				if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+
+	return server
+}
+
+// rsaSHA2Signer is an RSA host key that is only offered as rsa-sha2-512, as
+// OpenSSH 8.8 and later do by default.
+type rsaSHA2Signer struct {
+	ssh.AlgorithmSigner
+}
+
+type rsaSHA2PublicKey struct {
+	ssh.PublicKey
+}
+
+func (rsaSHA2PublicKey) Type() string {
+	return ssh.SigAlgoRSASHA2512
+}
+
+func (s rsaSHA2Signer) PublicKey() ssh.PublicKey {
+	return rsaSHA2PublicKey{s.AlgorithmSigner.PublicKey()}
+}
+
+func (s rsaSHA2Signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, ssh.SigAlgoRSASHA2512)
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go s.globalRequests(requests)
+
+	s.mu.Lock()
+	s.users = append(s.users, serverConn.User())
+	s.mu.Unlock()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			s.mu.Lock()
+			stall := s.stallForward
+			s.mu.Unlock()
+
+			if !stall {
+				go s.forward(newChannel)
+			}
+			continue
+		}
+
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.session(channel, channelRequests)
+	}
+}
+
+// globalRequests counts keepalives, leaving them unanswered once the server
+// has been made unresponsive.
+func (s *testSSHServer) globalRequests(requests <-chan *ssh.Request) {
+	for request := range requests {
+		s.mu.Lock()
+		if request.Type == "keepalive@openssh.com" {
+			s.keepalives++
+		}
+		unresponsive := s.unresponsive
+		s.mu.Unlock()
+
+		if !unresponsive && request.WantReply {
+			request.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testSSHServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for request := range requests {
+		switch request.Type {
+		case "exec":
+			var exec struct{ Command string }
+			ssh.Unmarshal(request.Payload, &exec)
+			request.Reply(true, nil)
+
+			s.mu.Lock()
+			s.commands = append(s.commands, exec.Command)
+			s.mu.Unlock()
+
+			go func() {
+				status := s.handler(exec.Command, channel)
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				channel.Close()
+			}()
+
+		case "env":
+			var env struct{ Name, Value string }
+			ssh.Unmarshal(request.Payload, &env)
+
+			s.mu.Lock()
+			if s.acceptEnv {
+				s.env = append(s.env, env.Name+"="+env.Value)
+			}
+			accept := s.acceptEnv
+			s.mu.Unlock()
+
+			request.Reply(accept, nil)
+
+		case "signal":
+			var signal struct{ Signal string }
+			ssh.Unmarshal(request.Payload, &signal)
+			s.signals <- signal.Signal
+
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testSSHServer) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	ssh.Unmarshal(newChannel.ExtraData(), &target)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	go io.Copy(channel, conn)
+	io.Copy(conn, channel)
+}
+
+func (s *testSSHServer) port() int {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func (s *testSSHServer) fingerprint() string {
+	return ssh.FingerprintSHA256(s.hostKey.PublicKey())
+}
+
+func (s *testSSHServer) Users() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.users...)
+}
+
+func (s *testSSHServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+func (s *testSSHServer) Env() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.env...)
+}
+
+func (s *testSSHServer) AcceptEnv() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceptEnv = true
+}
+
+// runInShell is a handler that runs commands with the local sh, in the
+// environment set by the client and with TMPDIR set to tmpDir.
+func (s *testSSHServer) runInShell(tmpDir string) func(string, ssh.Channel) uint32 {
+	return func(command string, channel ssh.Channel) uint32 {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = append(s.Env(), "PATH="+os.Getenv("PATH"), "TMPDIR="+tmpDir)
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return uint32(exitErr.Sys().(syscall.WaitStatus).ExitStatus())
+			}
+			return 255
+		}
+
+		return 0
+	}
+}
+
+func (s *testSSHServer) Keepalives() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keepalives
+}
+
+func (s *testSSHServer) StopResponding() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unresponsive = true
+}
+
+// StallForwards leaves direct-tcpip channel requests unanswered.
+func (s *testSSHServer) AuthorizeKey(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorized = append(s.authorized, key)
+}
+
+func (s *testSSHServer) StallForwards() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stallForward = true
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+}
+
+var _ = Describe("SSHClient", func() {
+	Describe("ExecuteOnRemote", func() {
+		var (
+			server *testSSHServer
+			client commands.SSHClient
+			stdout *omfakes.Logger
+			stderr *omfakes.Logger
+			input  commands.ExecuteOnRemoteInput
+		)
+
+		BeforeEach(func() {
+			server = newTestSSHServer()
+			stdout = &omfakes.Logger{}
+			stderr = &omfakes.Logger{}
+			client = commands.NewSSHClient(stdout, stderr)
+
+			input = commands.ExecuteOnRemoteInput{
+				Host:               "127.0.0.1",
+				Port:               server.port(),
+				User:               "tempest",
+				SSHPassword:        "secret",
+				HostKeyFingerprint: server.fingerprint(),
+				Command:            []string{"bosh", "deployments"},
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("runs the command as the given user on the given host and port", func() {
+			Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+			Expect(server.Users()).To(Equal([]string{"tempest"}))
+			Expect(server.Commands()).To(Equal([]string{"bosh deployments"}))
+		})
+
+		It("streams remote stdout and stderr to the given writers", func() {
+			server.handler = func(_ string, channel ssh.Channel) uint32 {
+				io.WriteString(channel, "Deployment 'cf'\n")
+				io.WriteString(channel.Stderr(), "Deprecation warning\n")
+				return 0
+			}
+
+			var remoteStdout, remoteStderr bytes.Buffer
+			input.Stdout = &remoteStdout
+			input.Stderr = &remoteStderr
+
+			Expect(client.ExecuteOnRemote(input)).To(Succeed())
+			Expect(remoteStdout.String()).To(Equal("Deployment 'cf'\n"))
+			Expect(remoteStderr.String()).To(Equal("Deprecation warning\n"))
+		})
+
+		It("logs remote output line by line to the client's loggers by default", func() {
+			server.handler = func(_ string, channel ssh.Channel) uint32 {
+				io.WriteString(channel, "Deploy")
+				io.WriteString(channel, "ment 'cf'\nDone")
+				io.WriteString(channel.Stderr(), "Deprecation warning\n")
+				return 0
+			}
+
+			Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+			Expect(stdout.PrintfCallCount()).To(Equal(2))
+			format, args := stdout.PrintfArgsForCall(0)
+			Expect(fmt.Sprintf(format, args...)).To(Equal("Deployment 'cf'\n"))
+			format, args = stdout.PrintfArgsForCall(1)
+			Expect(fmt.Sprintf(format, args...)).To(Equal("Done\n"))
+
+			Expect(stderr.PrintfCallCount()).To(Equal(1))
+			format, args = stderr.PrintfArgsForCall(0)
+			Expect(fmt.Sprintf(format, args...)).To(Equal("Deprecation warning\n"))
+		})
+
+		It("refuses interactive mode when stdin is not a terminal", func() {
+			input.Interactive = true
+
+			err := client.ExecuteOnRemote(input)
+			Expect(err).To(MatchError("interactive mode requires stdin to be a terminal"))
+			Expect(server.Users()).To(BeEmpty())
+		})
+
+		It("returns the remote exit status", func() {
+			server.handler = func(string, ssh.Channel) uint32 {
+				return 3
+			}
+
+			err := client.ExecuteOnRemote(input)
+			Expect(err).To(Equal(commands.RemoteExitError{ExitStatus: 3}))
+			Expect(err.Error()).To(Equal("remote command exited with status 3"))
+		})
+
+		It("returns an AuthError when the credentials are rejected", func() {
+			input.SSHPassword = "wrong"
+
+			err := client.ExecuteOnRemote(input)
+			Expect(err).To(BeAssignableToTypeOf(commands.AuthError{}))
+			Expect(err.(commands.AuthError).User).To(Equal("tempest"))
+		})
+
+		Context("when SSH_AUTH_SOCK points at an ssh-agent that is not running", func() {
+			var authSock string
+
+			BeforeEach(func() {
+				authSock = os.Getenv("SSH_AUTH_SOCK")
+				os.Setenv("SSH_AUTH_SOCK", "/nonexistent/agent.sock")
+			})
+
+			AfterEach(func() {
+				os.Setenv("SSH_AUTH_SOCK", authSock)
+			})
+
+			It("skips the agent with a warning when auth methods are not given", func() {
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+				Expect(stderr.PrintfCallCount()).To(Equal(1))
+				format, args := stderr.PrintfArgsForCall(0)
+				Expect(fmt.Sprintf(format, args...)).To(HavePrefix("Skipping ssh-agent auth, could not connect to ssh-agent: "))
+				Expect(server.Commands()).To(Equal([]string{"bosh deployments"}))
+			})
+
+			It("fails when agent auth was requested", func() {
+				input.AuthMethods = []string{"agent", "password"}
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(BeAssignableToTypeOf(commands.AuthError{}))
+				Expect(err).To(MatchError(ContainSubstring("could not connect to ssh-agent")))
+				Expect(server.Users()).To(BeEmpty())
+			})
+		})
+
+		It("returns an AuthError when the ssh key cannot be read", func() {
+			input.SSHPassword = ""
+			input.SSHKeyPath = "/nonexistent/key.pem"
+
+			err := client.ExecuteOnRemote(input)
+			Expect(err).To(BeAssignableToTypeOf(commands.AuthError{}))
+			Expect(err.(commands.AuthError).User).To(Equal("tempest"))
+			Expect(err).To(MatchError(ContainSubstring("could not read ssh key")))
+			Expect(server.Users()).To(BeEmpty())
+		})
+
+		It("returns a DialError when known_hosts cannot be parsed", func() {
+			dir, err := ioutil.TempDir("", "known-hosts")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			input.HostKeyFingerprint = ""
+			input.KnownHostsPath = filepath.Join(dir, "known_hosts")
+			Expect(ioutil.WriteFile(input.KnownHostsPath, []byte("127.0.0.1 ssh-ed25519 not-base64\n"), 0600)).To(Succeed())
+
+			err = client.ExecuteOnRemote(input)
+			Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+			Expect(err).To(MatchError(ContainSubstring("could not parse known hosts file")))
+			Expect(server.Users()).To(BeEmpty())
+		})
+
+		It("returns a DialError when the host cannot be reached", func() {
+			server.Close()
+
+			err := client.ExecuteOnRemote(input)
+			Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+			Expect(err.(commands.DialError).Address).To(Equal(fmt.Sprintf("127.0.0.1:%d", server.port())))
+		})
+
+		Context("when connecting fails with a transient error", func() {
+			BeforeEach(func() {
+				server.Close()
+				input.Retries = 2
+				input.RetryDelay = time.Millisecond
+			})
+
+			It("retries, logging each attempt", func() {
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+
+				Expect(stderr.PrintfCallCount()).To(Equal(3))
+				format, args := stderr.PrintfArgsForCall(0)
+				Expect(fmt.Sprintf(format, args...)).To(HavePrefix(fmt.Sprintf("Attempt 1 of 3 to connect to 127.0.0.1:%d failed: ", server.port())))
+				Expect(fmt.Sprintf(format, args...)).To(ContainSubstring("; retrying in "))
+				format, args = stderr.PrintfArgsForCall(2)
+				Expect(fmt.Sprintf(format, args...)).To(HavePrefix("Attempt 3 of 3"))
+				Expect(fmt.Sprintf(format, args...)).To(HaveSuffix("; giving up\n"))
+			})
+
+			It("matches retryable errors regardless of case", func() {
+				input.RetryableErrors = []string{"Connection Refused"}
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+				Expect(stderr.PrintfCallCount()).To(Equal(3))
+			})
+
+			It("does not retry errors that are not configured as retryable", func() {
+				input.RetryableErrors = []string{"unexpected message type 3"}
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+				Expect(stderr.PrintfCallCount()).To(Equal(0))
+			})
+		})
+
+		It("times out when the host accepts the connection but never responds", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+
+			go func() {
+				conn, err := listener.Accept()
+				if err == nil {
+					defer conn.Close()
+					ioutil.ReadAll(conn)
+				}
+			}()
+
+			input.Port = listener.Addr().(*net.TCPAddr).Port
+			input.ConnectTimeout = 50 * time.Millisecond
+
+			err = client.ExecuteOnRemote(input)
+			Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+			Expect(err.Error()).To(ContainSubstring("i/o timeout"))
+		})
+
+		It("returns a DialError wrapping the host key mismatch", func() {
+			input.HostKeyFingerprint = "SHA256:not-the-right-key"
+
+			err := client.ExecuteOnRemote(input)
+			Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+			Expect(err.(commands.DialError).Err).To(Equal(commands.HostKeyMismatchError{
+				Host:     fmt.Sprintf("[127.0.0.1]:%d", server.port()),
+				Expected: []string{"SHA256:not-the-right-key"},
+				Actual:   server.fingerprint(),
+			}))
+		})
+
+		It("runs several commands over one connection", func() {
+			conn, err := client.Connect(input)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			Expect(conn.ExecuteOnRemote(input)).To(Succeed())
+			input.Command = []string{"bosh", "vms"}
+			Expect(conn.ExecuteOnRemote(input)).To(Succeed())
+
+			Expect(server.Users()).To(HaveLen(1))
+			Expect(server.Commands()).To(Equal([]string{"bosh deployments", "bosh vms"}))
+		})
+
+		Describe("CaptureOnRemote", func() {
+			BeforeEach(func() {
+				server.handler = func(_ string, channel ssh.Channel) uint32 {
+					io.WriteString(channel, "Deployment 'cf'\n")
+					io.WriteString(channel.Stderr(), "Deprecation warning\n")
+					return 3
+				}
+			})
+
+			It("returns the output, exit status and duration of the command", func() {
+				output, err := client.CaptureOnRemote(input)
+				Expect(err).To(Equal(commands.RemoteExitError{ExitStatus: 3}))
+
+				Expect(string(output.Stdout)).To(Equal("Deployment 'cf'\n"))
+				Expect(string(output.Stderr)).To(Equal("Deprecation warning\n"))
+				Expect(output.StdoutTruncated).To(BeFalse())
+				Expect(output.ExitStatus).To(Equal(3))
+				Expect(output.Duration).To(BeNumerically(">", 0))
+
+				Expect(stdout.PrintfCallCount()).To(Equal(0))
+				Expect(stderr.PrintfCallCount()).To(Equal(0))
+			})
+
+			It("keeps no more than the capture limit", func() {
+				input.CaptureLimit = 10
+
+				output, _ := client.CaptureOnRemote(input)
+				Expect(string(output.Stdout)).To(Equal("Deployment"))
+				Expect(output.StdoutTruncated).To(BeTrue())
+				Expect(string(output.Stderr)).To(Equal("Deprecatio"))
+				Expect(output.StderrTruncated).To(BeTrue())
+			})
+
+			It("also streams the output in tee mode", func() {
+				var remoteStdout bytes.Buffer
+				input.Stdout = &remoteStdout
+				input.Tee = true
+
+				output, _ := client.CaptureOnRemote(input)
+				Expect(string(output.Stdout)).To(Equal("Deployment 'cf'\n"))
+				Expect(remoteStdout.String()).To(Equal("Deployment 'cf'\n"))
+
+				Expect(string(output.Stderr)).To(Equal("Deprecation warning\n"))
+				Expect(stderr.PrintfCallCount()).To(Equal(1))
+			})
+
+			It("reports an exit status of -1 when the command could not be run", func() {
+				server.Close()
+
+				output, err := client.CaptureOnRemote(input)
+				Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+				Expect(output.ExitStatus).To(Equal(-1))
+			})
+		})
+
+		Context("when arguments contain shell metacharacters", func() {
+			var remoteStdout *bytes.Buffer
+
+			BeforeEach(func() {
+				server.handler = server.runInShell(os.TempDir())
+
+				remoteStdout = &bytes.Buffer{}
+				input.Stdout = remoteStdout
+			})
+
+			It("quotes each argument for the remote shell", func() {
+				input.Command = []string{"printf", `%s\n`, "sudo monit summary", `it's "$HOME"`, "a;b", ""}
+
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(remoteStdout.String()).To(Equal("sudo monit summary\nit's \"$HOME\"\na;b\n\n"))
+				Expect(server.Commands()).To(Equal([]string{`printf '%s\n' 'sudo monit summary' 'it'\''s "$HOME"' 'a;b' ''`}))
+			})
+
+			It("leaves the raw command for the remote shell to interpret", func() {
+				input.Command = []string{"echo", "$ONE"}
+				input.RawCommand = "$((1 + 1)) | tr 2 3"
+
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(remoteStdout.String()).To(Equal("$ONE 3\n"))
+			})
+		})
+
+		Context("when environment variables are given", func() {
+			var (
+				tmpDir       string
+				remoteStdout *bytes.Buffer
+			)
+
+			BeforeEach(func() {
+				var err error
+				tmpDir, err = ioutil.TempDir("", "remote-env")
+				Expect(err).ToNot(HaveOccurred())
+
+				server.handler = server.runInShell(tmpDir)
+
+				remoteStdout = &bytes.Buffer{}
+				input.Stdout = remoteStdout
+				input.Env = []string{"BOSH_CLIENT=ops_manager", `BOSH_CLIENT_SECRET=it's a "$ecret"`}
+				input.Command = nil
+				input.RawCommand = `echo "$BOSH_CLIENT:$BOSH_CLIENT_SECRET"`
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(tmpDir)
+			})
+
+			It("sends them with setenv requests when the server accepts them", func() {
+				server.AcceptEnv()
+
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(remoteStdout.String()).To(Equal(`ops_manager:it's a "$ecret"` + "\n"))
+
+				Expect(server.Env()).To(Equal(input.Env))
+				Expect(server.Commands()).To(Equal([]string{`echo "$BOSH_CLIENT:$BOSH_CLIENT_SECRET"`}))
+			})
+
+			It("falls back to a temporary file that is removed afterwards", func() {
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(remoteStdout.String()).To(Equal(`ops_manager:it's a "$ecret"` + "\n"))
+
+				for _, command := range server.Commands() {
+					Expect(command).ToNot(ContainSubstring("ecret"))
+				}
+
+				files, err := ioutil.ReadDir(tmpDir)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(BeEmpty())
+			})
+
+			It("writes the temporary file readable only by the ssh user", func() {
+				var modes []os.FileMode
+				shell := server.runInShell(tmpDir)
+				server.handler = func(command string, channel ssh.Channel) uint32 {
+					status := shell(command, channel)
+					files, _ := ioutil.ReadDir(tmpDir)
+					for _, file := range files {
+						modes = append(modes, file.Mode())
+					}
+					return status
+				}
+
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(modes).To(Equal([]os.FileMode{0600}))
+			})
+
+			It("rejects malformed variables", func() {
+				input.Env = []string{"NOT A VARIABLE"}
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(MatchError(`invalid environment variable "NOT A VARIABLE", expected NAME=value`))
+				Expect(server.Commands()).To(BeEmpty())
+			})
+		})
+
+		Context("when the command runs for a long time", func() {
+			var release chan struct{}
+
+			BeforeEach(func() {
+				release = make(chan struct{})
+				server.handler = func(string, ssh.Channel) uint32 {
+					<-release
+					return 0
+				}
+				input.KeepaliveInterval = 10 * time.Millisecond
+			})
+
+			It("sends keepalives", func() {
+				go func() {
+					defer close(release)
+					Eventually(server.Keepalives).Should(BeNumerically(">=", 3))
+				}()
+
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+			})
+
+			It("returns a ConnectionLostError when keepalives go unanswered", func() {
+				defer close(release)
+				server.StopResponding()
+				input.KeepaliveTimeout = 50 * time.Millisecond
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(Equal(commands.ConnectionLostError{
+					Address: fmt.Sprintf("127.0.0.1:%d", server.port()),
+					Timeout: 50 * time.Millisecond,
+				}))
+			})
+		})
+
+		Context("when the host only has an RSA key and refuses ssh-rsa signatures", func() {
+			var (
+				rsaServer  *testSSHServer
+				rsaKey     ssh.PublicKey
+				knownHosts string
+			)
+
+			BeforeEach(func() {
+				private, err := rsa.GenerateKey(rand.Reader, 2048)
+				Expect(err).ToNot(HaveOccurred())
+				signer, err := ssh.NewSignerFromKey(private)
+				Expect(err).ToNot(HaveOccurred())
+				rsaKey = signer.PublicKey()
+
+				rsaServer = newTestSSHServerWithHostKey(rsaSHA2Signer{signer.(ssh.AlgorithmSigner)})
+
+				dir, err := ioutil.TempDir("", "known-hosts")
+				Expect(err).ToNot(HaveOccurred())
+				knownHosts = filepath.Join(dir, "known_hosts")
+
+				input.Port = rsaServer.port()
+				input.HostKeyFingerprint = ""
+				input.KnownHostsPath = knownHosts
+				input.TrustOnFirstUse = true
+			})
+
+			AfterEach(func() {
+				rsaServer.Close()
+				os.RemoveAll(filepath.Dir(knownHosts))
+			})
+
+			It("negotiates rsa-sha2 with the key recorded in known_hosts", func() {
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+				contents, err := ioutil.ReadFile(knownHosts)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring(string(ssh.MarshalAuthorizedKey(rsaKey))))
+
+				input.TrustOnFirstUse = false
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(rsaServer.Commands()).To(HaveLen(2))
+			})
+		})
+
+		Context("when a connection is held open without running a command", func() {
+			BeforeEach(func() {
+				input.KeepaliveInterval = 10 * time.Millisecond
+			})
+
+			It("sends keepalives", func() {
+				conn, err := client.Connect(input)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+
+				Eventually(server.Keepalives).Should(BeNumerically(">=", 3))
+			})
+
+			It("closes the connection when keepalives go unanswered", func() {
+				server.StopResponding()
+				input.KeepaliveTimeout = 50 * time.Millisecond
+
+				conn, err := client.Connect(input)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+
+				waited := make(chan error, 1)
+				go func() {
+					waited <- conn.Wait()
+				}()
+
+				Eventually(waited).Should(Receive(Equal(commands.ConnectionLostError{
+					Address: fmt.Sprintf("127.0.0.1:%d", server.port()),
+					Timeout: 50 * time.Millisecond,
+				})))
+			})
+		})
+
+		Context("when interrupted", func() {
+			var (
+				interrupts chan os.Signal
+				started    chan struct{}
+			)
+
+			BeforeEach(func() {
+				interrupts = make(chan os.Signal, 2)
+				started = make(chan struct{})
+				input.Interrupts = interrupts
+				input.InterruptGracePeriod = time.Minute
+			})
+
+			It("forwards the signal and reports the interruption", func() {
+				server.handler = func(string, ssh.Channel) uint32 {
+					close(started)
+					Expect(<-server.signals).To(Equal("INT"))
+					return 130
+				}
+
+				go func() {
+					<-started
+					interrupts <- os.Interrupt
+				}()
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(Equal(commands.InterruptedError{
+					Signal: ssh.SIGINT,
+					Err:    commands.RemoteExitError{ExitStatus: 130},
+				}))
+				Expect(err.(commands.InterruptedError).ExitStatus()).To(Equal(130))
+			})
+
+			It("closes the session when the command outlives the grace period", func() {
+				release := make(chan struct{})
+				defer close(release)
+
+				server.handler = func(string, ssh.Channel) uint32 {
+					close(started)
+					<-release
+					return 0
+				}
+				input.InterruptGracePeriod = 10 * time.Millisecond
+
+				go func() {
+					<-started
+					interrupts <- syscall.SIGTERM
+				}()
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(Equal(commands.InterruptedError{Signal: ssh.SIGTERM, Forced: true}))
+				Expect(err.(commands.InterruptedError).ExitStatus()).To(Equal(143))
+				Eventually(server.signals).Should(Receive(Equal("TERM")))
+			})
+		})
+
+		Context("when the ssh key is encrypted and no passphrase is given", func() {
+			var (
+				keyPath   string
+				prompts   int
+				restore   func()
+				publicKey ssh.PublicKey
+			)
+
+			BeforeEach(func() {
+				keyFile, err := ioutil.TempFile("", "ssh-key")
+				Expect(err).ToNot(HaveOccurred())
+				_, err = keyFile.WriteString(ed25519OpenSSHEncrypted)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(keyFile.Close()).To(Succeed())
+				keyPath = keyFile.Name()
+
+				publicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(ed25519OpenSSHEncryptedPublic))
+				Expect(err).ToNot(HaveOccurred())
+				server.AuthorizeKey(publicKey)
+
+				prompts = 0
+				restore = commands.SetReadPassphrase(func(string) ([]byte, error) {
+					prompts++
+					return []byte("correct horse"), nil
+				})
+
+				input.SSHPassword = ""
+				input.SSHKeyPath = keyPath
+				input.AuthMethods = []string{commands.AuthMethodKey}
+			})
+
+			AfterEach(func() {
+				restore()
+				os.Remove(keyPath)
+			})
+
+			It("asks for the passphrase once across jump hosts and redials", func() {
+				bastion := newTestSSHServer()
+				defer bastion.Close()
+				bastion.AuthorizeKey(publicKey)
+
+				var err error
+				input.JumpHosts, err = commands.ParseJumpHosts(fmt.Sprintf("jumper@127.0.0.1:%d", bastion.port()))
+				Expect(err).ToNot(HaveOccurred())
+				input.HostKeyFingerprint = ""
+				input.KnownHostsPath = filepath.Join(os.TempDir(), fmt.Sprintf("known_hosts-%d", server.port()))
+				input.TrustOnFirstUse = true
+				defer os.Remove(input.KnownHostsPath)
+
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+				Expect(bastion.Users()).To(Equal([]string{"jumper", "jumper"}))
+				Expect(server.Commands()).To(HaveLen(2))
+				Expect(prompts).To(Equal(1))
+			})
+		})
+
+		Context("when jump hosts are given", func() {
+			var (
+				bastion    *testSSHServer
+				knownHosts string
+			)
+
+			BeforeEach(func() {
+				bastion = newTestSSHServer()
+
+				dir, err := ioutil.TempDir("", "jump-host")
+				Expect(err).ToNot(HaveOccurred())
+				knownHosts = filepath.Join(dir, "known_hosts")
+
+				os.Setenv("BASTION_PASSWORD", "secret")
+				input.JumpHosts, err = commands.ParseJumpHosts(fmt.Sprintf("jumper@127.0.0.1:%d?password-env=BASTION_PASSWORD", bastion.port()))
+				Expect(err).ToNot(HaveOccurred())
+				input.KnownHostsPath = knownHosts
+				input.TrustOnFirstUse = true
+			})
+
+			AfterEach(func() {
+				bastion.Close()
+				os.Unsetenv("BASTION_PASSWORD")
+				os.RemoveAll(filepath.Dir(knownHosts))
+			})
+
+			It("reaches the Ops Manager VM through them", func() {
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+				Expect(bastion.Users()).To(Equal([]string{"jumper"}))
+				Expect(bastion.Commands()).To(BeEmpty())
+				Expect(server.Users()).To(Equal([]string{"tempest"}))
+				Expect(server.Commands()).To(Equal([]string{"bosh deployments"}))
+			})
+
+			It("verifies the jump host key against known_hosts", func() {
+				Expect(client.ExecuteOnRemote(input)).To(Succeed())
+
+				contents, err := ioutil.ReadFile(knownHosts)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring(fmt.Sprintf("[127.0.0.1]:%d", bastion.port())))
+				Expect(string(contents)).ToNot(ContainSubstring(fmt.Sprintf("[127.0.0.1]:%d", server.port())))
+			})
+
+			It("times out when the jump host never opens the connection", func() {
+				bastion.StallForwards()
+				input.ConnectTimeout = 500 * time.Millisecond
+
+				err := client.ExecuteOnRemote(input)
+				Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+				Expect(err.(commands.DialError).Address).To(Equal(fmt.Sprintf("127.0.0.1:%d", server.port())))
+				Expect(err.Error()).To(ContainSubstring("i/o timeout"))
+				Expect(server.Users()).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,99 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"time"
+)
+
+// DialError is returned when the ssh connection to a host, or a jump host on
+// the way to it, could not be established. Host key verification failures are
+// reported as a DialError wrapping the verification error.
+type DialError struct {
+	Address string
+	Err     error
+}
+
+func (e DialError) Error() string {
+	return fmt.Sprintf("could not connect to %s: %s", e.Address, e.Err)
+}
+
+func (e DialError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when none of the offered credentials were accepted.
+type AuthError struct {
+	Address string
+	User    string
+	Err     error
+}
+
+func (e AuthError) Error() string {
+	return fmt.Sprintf("could not authenticate to %s as %s: %s", e.Address, e.User, e.Err)
+}
+
+func (e AuthError) Unwrap() error {
+	return e.Err
+}
+
+// SessionError is returned when the connection was established but the
+// remote command could not be run to completion.
+type SessionError struct {
+	Err error
+}
+
+func (e SessionError) Error() string {
+	return fmt.Sprintf("ssh session failed: %s", e.Err)
+}
+
+func (e SessionError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectionLostError is returned when the connection stopped answering
+// keepalives while the remote command was running. The command may still be
+// running on the Ops Manager VM.
+type ConnectionLostError struct {
+	Address string
+	Timeout time.Duration
+}
+
+func (e ConnectionLostError) Error() string {
+	return fmt.Sprintf("connection to %s lost: no response to keepalives for %s", e.Address, e.Timeout)
+}
+
+// RemoteExitError is returned when the remote command ran and exited
+// unsuccessfully. Commands killed by a signal report 128 plus the signal
+// number as their ExitStatus, as a shell would.
+type RemoteExitError struct {
+	ExitStatus int
+	Signal     string
+	Message    string
+}
+
+func (e RemoteExitError) Error() string {
+	msg := fmt.Sprintf("remote command exited with status %d", e.ExitStatus)
+	if e.Signal != "" {
+		msg += fmt.Sprintf(" from signal %s", e.Signal)
+	}
+	if e.Message != "" {
+		msg += fmt.Sprintf(": %s", e.Message)
+	}
+
+	return msg
+}
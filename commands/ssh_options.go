@@ -0,0 +1,114 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// SSHOptions are the flags for reaching the Ops Manager VM over ssh, shared
+// by every command that does.
+type SSHOptions struct {
+	SSHKeyPath         string        `short:"i" long:"ssh-key-path" description:"path to ssh key"`
+	SSHKeyPassphrase   string        `long:"ssh-key-passphrase" description:"passphrase for an encrypted ssh key"`
+	SSHKeyPassFile     string        `long:"ssh-key-passphrase-file" description:"path to a file containing the passphrase for an encrypted ssh key"`
+	SSHPassword        string        `long:"ssh-password" description:"opsman ssh password"`
+	SSHAuth            string        `long:"ssh-auth" description:"comma separated ssh auth methods to try in order: agent, key, password (defaults to every method with credentials)"`
+	SSHUser            string        `long:"ssh-user" description:"opsman ssh user" default:"ubuntu"`
+	SSHPort            int           `long:"ssh-port" description:"opsman ssh port" default:"22"`
+	SSHHost            string        `long:"ssh-host" description:"opsman ssh host, when it differs from the target host"`
+	JumpHosts          string        `long:"jump-host" repeatable:"true" description:"comma separated jump hosts to reach opsman through, in order, each as [user@]host[:port][?key=path&auth=agent+key&password-env=VAR]; may be repeated"`
+	KnownHosts         string        `long:"known-hosts" description:"path to the known_hosts file used to verify the opsman ssh host key (defaults to ~/.ssh/known_hosts)"`
+	TrustOnFirstUse    bool          `long:"trust-on-first-use" description:"record the opsman ssh host key in known_hosts if it is not already known"`
+	HostKeyFingerprint string        `long:"ssh-host-key-fingerprint" description:"expected SHA256 fingerprint of the opsman ssh host key, instead of known_hosts"`
+	ConnectTimeout     time.Duration `long:"ssh-connect-timeout" description:"timeout for establishing each ssh connection" default:"30s"`
+	Retries            int           `long:"ssh-retries" description:"number of times to retry a failed ssh connection" default:"3"`
+	KeepaliveInterval  time.Duration `long:"ssh-keepalive-interval" description:"how often to send ssh keepalives while connected, 0s to disable" default:"30s"`
+	KeepaliveTimeout   time.Duration `long:"ssh-keepalive-timeout" description:"how long keepalives may go unanswered before the connection is considered lost (defaults to three intervals)"`
+	RetryOn            string        `long:"ssh-retry-on" description:"comma separated errors to retry the ssh connection on (defaults to refused, reset, closed and timed out connections and sshd still starting up)"`
+}
+
+// input validates the options and returns the connection settings for
+// reaching host, or the --ssh-host given instead of it.
+func (o SSHOptions) input(host string) (ExecuteOnRemoteInput, error) {
+	if o.SSHKeyPath == "" && o.SSHPassword == "" && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return ExecuteOnRemoteInput{}, fmt.Errorf("either ssh key path, the opsman ssh password or a running ssh-agent must be provided")
+	}
+
+	authMethods, err := ParseAuthMethods(o.SSHAuth)
+	if err != nil {
+		return ExecuteOnRemoteInput{}, err
+	}
+
+	keyPassphrase, err := o.keyPassphrase()
+	if err != nil {
+		return ExecuteOnRemoteInput{}, err
+	}
+
+	jumpHosts, err := ParseJumpHosts(o.JumpHosts)
+	if err != nil {
+		return ExecuteOnRemoteInput{}, err
+	}
+
+	if o.SSHHost != "" {
+		host = o.SSHHost
+	}
+
+	keepaliveInterval := o.KeepaliveInterval
+	if keepaliveInterval == 0 {
+		keepaliveInterval = -1
+	}
+
+	return ExecuteOnRemoteInput{
+		Host:               host,
+		Port:               o.SSHPort,
+		User:               o.SSHUser,
+		SSHKeyPath:         o.SSHKeyPath,
+		SSHKeyPassphrase:   keyPassphrase,
+		SSHPassword:        o.SSHPassword,
+		AuthMethods:        authMethods,
+		KnownHostsPath:     o.KnownHosts,
+		TrustOnFirstUse:    o.TrustOnFirstUse,
+		HostKeyFingerprint: o.HostKeyFingerprint,
+		JumpHosts:          jumpHosts,
+		ConnectTimeout:     o.ConnectTimeout,
+		Retries:            o.Retries,
+		RetryableErrors:    ParseRetryableErrors(o.RetryOn),
+		KeepaliveInterval:  keepaliveInterval,
+		KeepaliveTimeout:   o.KeepaliveTimeout,
+	}, nil
+}
+
+func (o SSHOptions) keyPassphrase() (string, error) {
+	if o.SSHKeyPassFile == "" {
+		return o.SSHKeyPassphrase, nil
+	}
+
+	if o.SSHKeyPassphrase != "" {
+		return "", fmt.Errorf("only one of ssh key passphrase or ssh key passphrase file may be provided")
+	}
+
+	contents, err := ioutil.ReadFile(o.SSHKeyPassFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read ssh key passphrase file: %s", err)
+	}
+
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
@@ -0,0 +1,406 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progress is the part of the om progress bar that transfers use.
+type progress interface {
+	SetTotal(int64)
+	NewBarReader(io.Reader) io.Reader
+	Kickoff()
+	End()
+}
+
+// ChecksumMismatchError is returned when a transferred file's SHA256 differs
+// on either side.
+type ChecksumMismatchError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected sha256 %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// transfer copies files to and from the Ops Manager VM by streaming them
+// through cat over ssh sessions, and checks their SHA256 with sha256sum
+// afterwards. Files are written to a .part file alongside their destination
+// first. When an attempt fails, the next one reconnects and carries on from
+// however much of the .part file was written, until retries run out.
+type transfer struct {
+	ssh        SSHClient
+	input      ExecuteOnRemoteInput
+	progress   progress
+	stderr     logger
+	retries    int
+	retryDelay time.Duration
+}
+
+// upload copies localPath to remotePath and returns its SHA256.
+func (t transfer) upload(localPath, remotePath string) (string, error) {
+	bar := &transferProgress{bar: t.progress}
+	defer bar.end()
+
+	return t.retry(func(conn SSHConnection) (string, error) {
+		return t.uploadOnce(conn, bar, localPath, remotePath)
+	})
+}
+
+// download copies remotePath to localPath and returns its SHA256.
+func (t transfer) download(remotePath, localPath string) (string, error) {
+	bar := &transferProgress{bar: t.progress}
+	defer bar.end()
+
+	return t.retry(func(conn SSHConnection) (string, error) {
+		return t.downloadOnce(conn, bar, remotePath, localPath)
+	})
+}
+
+func (t transfer) retry(attempt func(SSHConnection) (string, error)) (string, error) {
+	retry := retryPolicy{retries: t.retries, delay: t.retryDelay}
+	if retry.delay == 0 {
+		retry.delay = defaultRetryDelay
+	}
+
+	for i := 1; ; i++ {
+		sum, err := t.attempt(attempt)
+		if err == nil {
+			return sum, nil
+		}
+
+		if i > retry.retries || !canResume(err) {
+			return "", err
+		}
+
+		delay := retry.backoff(i)
+		t.stderr.Printf("Transfer attempt %d of %d failed: %s; resuming in %s\n", i, retry.retries+1, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+func (t transfer) attempt(attempt func(SSHConnection) (string, error)) (string, error) {
+	conn, err := t.ssh.Connect(t.input)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return attempt(conn)
+}
+
+// canResume reports whether a failed transfer is worth another attempt.
+// Checksum mismatches are, as the partial file is discarded first.
+func canResume(err error) bool {
+	switch err.(type) {
+	case DialError, SessionError, ConnectionLostError, ChecksumMismatchError:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t transfer) uploadOnce(conn SSHConnection, bar *transferProgress, localPath, remotePath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open %s: %s", localPath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("could not open %s: %s", localPath, err)
+	}
+
+	partPath := remotePath + ".part"
+	offset, err := t.remoteSize(conn, partPath, true)
+	if err != nil {
+		return "", err
+	}
+	if offset > info.Size() {
+		offset = 0
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, file, offset); err != nil {
+		return "", fmt.Errorf("could not read %s: %s", localPath, err)
+	}
+
+	redirect := "> "
+	if offset > 0 {
+		redirect = ">> "
+		t.stderr.Printf("Resuming upload of %s at byte %d\n", localPath, offset)
+	}
+
+	bar.start(info.Size(), offset)
+
+	input := t.input
+	input.RawCommand = "cat " + redirect + shellQuote(partPath)
+	input.Stdin = bar.reader(io.TeeReader(file, hash), offset)
+	if err := conn.ExecuteOnRemote(input); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err := t.verify(conn, partPath, sum); err != nil {
+		if _, ok := err.(ChecksumMismatchError); ok {
+			t.run(conn, "rm -f "+shellQuote(partPath))
+		}
+		return "", err
+	}
+
+	if err := t.run(conn, "mv -f "+shellQuote(partPath)+" "+shellQuote(remotePath)); err != nil {
+		return "", fmt.Errorf("could not move %s into place: %s", partPath, err)
+	}
+
+	return sum, nil
+}
+
+func (t transfer) downloadOnce(conn SSHConnection, bar *transferProgress, remotePath, localPath string) (string, error) {
+	size, err := t.remoteSize(conn, remotePath, false)
+	if err != nil {
+		return "", err
+	}
+
+	partPath := localPath + ".part"
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return "", fmt.Errorf("could not create %s: %s", partPath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	offset, err := hashFile(partPath, hash)
+	if err != nil {
+		return "", err
+	}
+	if offset > size {
+		if err := file.Truncate(0); err != nil {
+			return "", fmt.Errorf("could not truncate %s: %s", partPath, err)
+		}
+		offset = 0
+		hash.Reset()
+	}
+
+	command := "cat " + shellQuote(remotePath)
+	if offset > 0 {
+		command = fmt.Sprintf("tail -c +%d %s", offset+1, shellQuote(remotePath))
+		t.stderr.Printf("Resuming download of %s at byte %d\n", remotePath, offset)
+	}
+
+	bar.start(size, offset)
+
+	// The progress bar counts what is read through it, so the remote output
+	// is piped through one on its way to the file.
+	r, w := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.MultiWriter(file, hash), bar.reader(r, offset))
+		r.CloseWithError(err)
+		copied <- err
+	}()
+
+	input := t.input
+	input.RawCommand = command
+	input.Stdout = w
+	err = conn.ExecuteOnRemote(input)
+	w.CloseWithError(err)
+	copyErr := <-copied
+
+	if copyErr != nil && copyErr != err {
+		return "", fmt.Errorf("could not write %s: %s", partPath, copyErr)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err := t.verify(conn, remotePath, sum); err != nil {
+		if _, ok := err.(ChecksumMismatchError); ok {
+			os.Remove(partPath)
+		}
+		return "", err
+	}
+
+	file.Close()
+	if err := os.Rename(partPath, localPath); err != nil {
+		return "", fmt.Errorf("could not move %s into place: %s", partPath, err)
+	}
+
+	return sum, nil
+}
+
+// transferProgress drives a single progress bar across every attempt of a
+// transfer, as the om bar can only be started and finished once. The bar
+// only counts bytes read through it, so each attempt advances it to where
+// the attempt resumes and bytes that it has already counted are not counted
+// again.
+type transferProgress struct {
+	bar     progress
+	started bool
+	counted int64
+}
+
+func (p *transferProgress) start(size, offset int64) {
+	p.bar.SetTotal(size)
+	if !p.started {
+		p.bar.Kickoff()
+		p.started = true
+	}
+
+	if offset > p.counted {
+		io.CopyN(ioutil.Discard, p.bar.NewBarReader(zeros{}), offset-p.counted)
+		p.counted = offset
+	}
+}
+
+// reader counts the bytes read from r, which start at offset in the file,
+// on the bar.
+func (p *transferProgress) reader(r io.Reader, offset int64) io.Reader {
+	return &progressReader{progress: p, r: r, position: offset}
+}
+
+func (p *transferProgress) end() {
+	if p.started {
+		p.bar.End()
+	}
+}
+
+type progressReader struct {
+	progress *transferProgress
+	r        io.Reader
+	position int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+
+	end := r.position + int64(n)
+	if end > r.progress.counted {
+		skip := r.progress.counted - r.position
+		if skip < 0 {
+			skip = 0
+		}
+		io.Copy(ioutil.Discard, r.progress.bar.NewBarReader(bytes.NewReader(b[skip:n])))
+		r.progress.counted = end
+	}
+	r.position = end
+
+	return n, err
+}
+
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// hashFile writes the contents of path to hash and returns its size.
+func hashFile(path string, hash io.Writer) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %s", path, err)
+	}
+	defer file.Close()
+
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %s", path, err)
+	}
+
+	return n, nil
+}
+
+func (t transfer) run(conn SSHConnection, command string) error {
+	input := t.input
+	input.RawCommand = command
+
+	output, err := conn.CaptureOnRemote(input)
+	if err != nil {
+		if stderr := strings.TrimSpace(string(output.Stderr)); stderr != "" {
+			return fmt.Errorf("%s", stderr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// verify compares the SHA256 of the remote file with that of the bytes that
+// were transferred.
+func (t transfer) verify(conn SSHConnection, remotePath, sum string) error {
+	input := t.input
+	input.RawCommand = "sha256sum " + shellQuote(remotePath)
+
+	output, err := conn.CaptureOnRemote(input)
+	if err != nil {
+		if stderr := strings.TrimSpace(string(output.Stderr)); stderr != "" {
+			return fmt.Errorf("could not checksum %s: %s", remotePath, stderr)
+		}
+		return fmt.Errorf("could not checksum %s: %s", remotePath, err)
+	}
+
+	fields := strings.Fields(string(output.Stdout))
+	if len(fields) == 0 {
+		return fmt.Errorf("could not checksum %s: no output from sha256sum", remotePath)
+	}
+
+	if fields[0] != sum {
+		return ChecksumMismatchError{Path: remotePath, Expected: sum, Actual: fields[0]}
+	}
+
+	return nil
+}
+
+// remoteSize returns the size of remotePath, or 0 if it does not exist and
+// missingOK is set.
+func (t transfer) remoteSize(conn SSHConnection, remotePath string, missingOK bool) (int64, error) {
+	input := t.input
+	input.RawCommand = "wc -c < " + shellQuote(remotePath)
+	if missingOK {
+		input.RawCommand = fmt.Sprintf("if [ -f %[1]s ]; then wc -c < %[1]s; else echo 0; fi", shellQuote(remotePath))
+	}
+
+	output, err := conn.CaptureOnRemote(input)
+	if err != nil {
+		if stderr := strings.TrimSpace(string(output.Stderr)); stderr != "" {
+			return 0, fmt.Errorf("could not find the size of %s: %s", remotePath, stderr)
+		}
+		return 0, fmt.Errorf("could not find the size of %s: %s", remotePath, err)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(output.Stdout)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not find the size of %s: %s", remotePath, err)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,157 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pivotal-cf/om/commands"
+)
+
+type Tunnel struct {
+	requestService requestService
+	ssh            SSHClient
+	stdout         logger
+	stderr         logger
+	host           string
+	SSHOptions     SSHOptions
+	Options        struct {
+		Listen string `short:"l" long:"listen" description:"local address for the SOCKS5 proxy to listen on" default:"127.0.0.1:0"`
+	}
+}
+
+func NewTunnelCommand(rs requestService, ssh SSHClient, host string, stdout, stderr logger) Tunnel {
+	return Tunnel{requestService: rs, ssh: ssh, host: host, stdout: stdout, stderr: stderr}
+}
+
+func (t Tunnel) Usage() commands.Usage {
+	return commands.Usage{
+		Description:      "Opens a SOCKS5 proxy through the OpsManager VM so that a local bosh CLI can reach the director",
+		ShortDescription: "Opens a SOCKS5 proxy to the director through the OpsManager VM",
+		Flags:            usageFlags(&t.SSHOptions, &t.Options),
+	}
+}
+
+// Execute prints the environment for the bosh CLI to use the tunnel and then
+// serves it until interrupted or the ssh connection closes.
+func (t Tunnel) Execute(args []string) error {
+	_, err := parseFlags(args, &t.SSHOptions, &t.Options)
+	if err != nil {
+		return fmt.Errorf("could not parse tunnel flags: %s", err)
+	}
+
+	input, err := t.SSHOptions.input(t.host)
+	if err != nil {
+		return err
+	}
+
+	creds, err := getDirectorCredentials(t.requestService)
+	if err != nil {
+		return err
+	}
+
+	conn, err := t.ssh.Connect(input)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	input.RawCommand = "cat " + shellQuote(creds.CACertPath)
+	ca, err := conn.CaptureOnRemote(input)
+	if err != nil {
+		return fmt.Errorf("could not read the director's CA certificate: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", t.Options.Listen)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %s", t.Options.Listen, err)
+	}
+	defer listener.Close()
+
+	t.stdout.Printf("export BOSH_ALL_PROXY=socks5://%s\n", listener.Addr())
+	t.stdout.Printf("export BOSH_ENVIRONMENT=%s\n", shellQuote(creds.Address))
+	t.stdout.Printf("export BOSH_CLIENT=%s\n", shellQuote(creds.Client))
+	t.stdout.Printf("export BOSH_CLIENT_SECRET=%s\n", shellQuote(creds.ClientSecret))
+	t.stdout.Printf("export BOSH_CA_CERT=%s\n", shellQuote(strings.TrimSpace(string(ca.Stdout))))
+	t.stderr.Printf("Tunnelling to the director at %s through %s; press Ctrl-C to close the tunnel\n", creds.Address, t.host)
+
+	go t.serve(listener, conn)
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- conn.Wait()
+	}()
+
+	select {
+	case <-interrupts:
+		t.stderr.Printf("Closing the tunnel\n")
+		return nil
+	case err := <-closed:
+		if _, ok := err.(ConnectionLostError); ok {
+			return err
+		}
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		return SessionError{Err: err}
+	}
+}
+
+// serve accepts SOCKS5 clients until listener is closed, connecting each to
+// the address it asks for from the Ops Manager VM.
+func (t Tunnel) serve(listener net.Listener, conn SSHConnection) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			address, err := socks5Handshake(client)
+			if err != nil {
+				t.stderr.Printf("SOCKS5 request failed: %s\n", err)
+				client.Close()
+				return
+			}
+
+			remote, err := conn.Dial("tcp", address)
+			if err != nil {
+				t.stderr.Printf("Could not connect to %s through the OpsManager VM: %s\n", address, err)
+				socks5Reply(client, socks5Failure)
+				client.Close()
+				return
+			}
+
+			if err := socks5Reply(client, socks5Succeeded); err != nil {
+				client.Close()
+				remote.Close()
+				return
+			}
+
+			pipe(client, remote)
+		}()
+	}
+}
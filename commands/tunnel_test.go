@@ -0,0 +1,217 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	"github.com/pivotal-cf/execute-on-opsman/commands/fakes"
+	"github.com/pivotal-cf/om/api"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tunnel", func() {
+	Describe("Execute", func() {
+		var (
+			command        commands.Tunnel
+			requestService *omfakes.RequestService
+			sshClient      *fakes.SSHClient
+			connection     *fakes.SSHConnection
+			stdout         *omfakes.Logger
+			stderr         *omfakes.Logger
+			echo           net.Listener
+			closed         chan struct{}
+		)
+
+		BeforeEach(func() {
+			requestService = &omfakes.RequestService{}
+			requestService.InvokeReturns(api.RequestServiceInvokeOutput{
+				StatusCode: http.StatusOK,
+				Body:       strings.NewReader(`{"credential": "BOSH_CLIENT=ops_manager BOSH_CLIENT_SECRET=opsman_secret BOSH_CA_CERT=/var/tempest/workspaces/default/root_ca_certificate BOSH_ENVIRONMENT=10.0.4.2 bosh "}`),
+			}, nil)
+
+			var err error
+			echo, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			go func(listener net.Listener) {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						io.Copy(conn, conn)
+						conn.Close()
+					}()
+				}
+			}(echo)
+
+			closed = make(chan struct{})
+			connection = &fakes.SSHConnection{}
+			connection.CaptureOnRemoteReturns(commands.ExecuteOnRemoteOutput{
+				Stdout: []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+			}, nil)
+			connection.DialStub = func(network, address string) (net.Conn, error) {
+				return net.Dial(network, echo.Addr().String())
+			}
+			connection.WaitStub = func() error {
+				<-closed
+				return io.EOF
+			}
+
+			sshClient = &fakes.SSHClient{}
+			sshClient.ConnectReturns(connection, nil)
+			stdout = &omfakes.Logger{}
+			stderr = &omfakes.Logger{}
+
+			command = commands.NewTunnelCommand(requestService, sshClient, "pcf.example.com", stdout, stderr)
+		})
+
+		AfterEach(func() {
+			echo.Close()
+		})
+
+		// start runs the tunnel in the background and returns the proxy
+		// address once the exports have been printed.
+		start := func() (string, <-chan error) {
+			done := make(chan error, 1)
+			go func() {
+				done <- command.Execute([]string{"--ssh-password", "secret"})
+			}()
+
+			Eventually(stdout.PrintfCallCount).Should(Equal(5))
+			format, v := stdout.PrintfArgsForCall(0)
+			export := fmt.Sprintf(format, v...)
+			Expect(export).To(HavePrefix("export BOSH_ALL_PROXY=socks5://127.0.0.1:"))
+
+			return strings.TrimSpace(strings.TrimPrefix(export, "export BOSH_ALL_PROXY=socks5://")), done
+		}
+
+		// connect makes a SOCKS5 CONNECT request for host:port through the
+		// proxy and returns the connection and the reply status.
+		connect := func(proxy, host string, port int) (net.Conn, byte) {
+			conn, err := net.Dial("tcp", proxy)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = conn.Write([]byte{5, 1, 0})
+			Expect(err).ToNot(HaveOccurred())
+			method := make([]byte, 2)
+			_, err = io.ReadFull(conn, method)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(method).To(Equal([]byte{5, 0}))
+
+			request := append([]byte{5, 1, 0, 3, byte(len(host))}, host...)
+			_, err = conn.Write(append(request, byte(port>>8), byte(port)))
+			Expect(err).ToNot(HaveOccurred())
+
+			reply := make([]byte, 10)
+			_, err = io.ReadFull(conn, reply)
+			Expect(err).ToNot(HaveOccurred())
+
+			return conn, reply[1]
+		}
+
+		It("prints the environment for the bosh CLI", func() {
+			_, done := start()
+			defer func() {
+				close(closed)
+				<-done
+			}()
+
+			var exports []string
+			for i := 0; i < stdout.PrintfCallCount(); i++ {
+				format, v := stdout.PrintfArgsForCall(i)
+				exports = append(exports, fmt.Sprintf(format, v...))
+			}
+			Expect(exports[1:]).To(Equal([]string{
+				"export BOSH_ENVIRONMENT=10.0.4.2\n",
+				"export BOSH_CLIENT=ops_manager\n",
+				"export BOSH_CLIENT_SECRET=opsman_secret\n",
+				"export BOSH_CA_CERT='-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----'\n",
+			}))
+
+			Expect(connection.CaptureOnRemoteArgsForCall(0).RawCommand).To(Equal("cat /var/tempest/workspaces/default/root_ca_certificate"))
+			Expect(sshClient.ConnectArgsForCall(0).SSHPassword).To(Equal("secret"))
+		})
+
+		It("forwards SOCKS5 connections through the ssh connection", func() {
+			proxy, done := start()
+			defer func() {
+				close(closed)
+				<-done
+			}()
+
+			conn, status := connect(proxy, "10.0.4.2", 25555)
+			defer conn.Close()
+			Expect(status).To(Equal(byte(0)))
+
+			_, err := conn.Write([]byte("ping"))
+			Expect(err).ToNot(HaveOccurred())
+			pong := make([]byte, 4)
+			_, err = io.ReadFull(conn, pong)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(pong)).To(Equal("ping"))
+
+			network, address := connection.DialArgsForCall(0)
+			Expect(network).To(Equal("tcp"))
+			Expect(address).To(Equal("10.0.4.2:25555"))
+		})
+
+		It("tells the SOCKS5 client when the connection cannot be made", func() {
+			connection.DialReturns(nil, errors.New("connection refused"))
+
+			proxy, done := start()
+			defer func() {
+				close(closed)
+				<-done
+			}()
+
+			conn, status := connect(proxy, "10.0.4.2", 8443)
+			defer conn.Close()
+			Expect(status).To(Equal(byte(1)))
+
+			Eventually(stderr.PrintfCallCount).Should(Equal(2))
+			format, v := stderr.PrintfArgsForCall(1)
+			Expect(fmt.Sprintf(format, v...)).To(Equal("Could not connect to 10.0.4.2:8443 through the OpsManager VM: connection refused\n"))
+		})
+
+		It("stops when the ssh connection closes", func() {
+			_, done := start()
+			close(closed)
+
+			var err error
+			Eventually(done).Should(Receive(&err))
+			Expect(err).To(BeAssignableToTypeOf(commands.SessionError{}))
+			Expect(connection.CloseCallCount()).To(Equal(1))
+		})
+
+		It("returns the connection error", func() {
+			sshClient.ConnectReturns(nil, commands.DialError{Address: "pcf.example.com:22", Err: errors.New("refused")})
+
+			err := command.Execute([]string{"--ssh-password", "secret"})
+			Expect(err).To(BeAssignableToTypeOf(commands.DialError{}))
+		})
+	})
+})
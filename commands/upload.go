@@ -0,0 +1,88 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/pivotal-cf/om/commands"
+)
+
+type Upload struct {
+	ssh        SSHClient
+	progress   progress
+	stdout     logger
+	stderr     logger
+	host       string
+	SSHOptions SSHOptions
+	Options    struct {
+		File               string        `short:"f" long:"file" description:"path to the file to upload"`
+		RemotePath         string        `short:"r" long:"remote-path" description:"path to upload the file to, relative to the ssh user's home directory (defaults to the file's name)"`
+		TransferRetries    int           `long:"transfer-retries" description:"number of times to resume a failed upload" default:"5"`
+		TransferRetryDelay time.Duration `long:"transfer-retry-delay" description:"how long to wait before first resuming a failed upload, doubling with each attempt" default:"1s"`
+	}
+}
+
+func NewUploadCommand(ssh SSHClient, host string, progress progress, stdout, stderr logger) Upload {
+	return Upload{ssh: ssh, host: host, progress: progress, stdout: stdout, stderr: stderr}
+}
+
+func (u Upload) Usage() commands.Usage {
+	return commands.Usage{
+		Description:      "Uploads a file to the OpsManager VM and verifies its checksum",
+		ShortDescription: "Uploads a file to the OpsManager VM",
+		Flags:            usageFlags(&u.SSHOptions, &u.Options),
+	}
+}
+
+func (u Upload) Execute(args []string) error {
+	_, err := parseFlags(args, &u.SSHOptions, &u.Options)
+	if err != nil {
+		return fmt.Errorf("could not parse upload flags: %s", err)
+	}
+
+	if u.Options.File == "" {
+		return fmt.Errorf("a file to upload must be provided")
+	}
+
+	remotePath := u.Options.RemotePath
+	if remotePath == "" {
+		remotePath = filepath.Base(u.Options.File)
+	}
+
+	input, err := u.SSHOptions.input(u.host)
+	if err != nil {
+		return err
+	}
+
+	sum, err := transfer{
+		ssh:        u.ssh,
+		input:      input,
+		progress:   u.progress,
+		stderr:     u.stderr,
+		retries:    u.Options.TransferRetries,
+		retryDelay: u.Options.TransferRetryDelay,
+	}.upload(u.Options.File, remotePath)
+	if err != nil {
+		return err
+	}
+
+	u.stdout.Printf("Uploaded %s to %s (sha256 %s)\n", u.Options.File, remotePath, sum)
+
+	return nil
+}
@@ -0,0 +1,186 @@
+/**
+ * Copyright 2017 Pivotal Software, Inc.
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package commands_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-cf/execute-on-opsman/commands"
+	"github.com/pivotal-cf/execute-on-opsman/commands/fakes"
+	apifakes "github.com/pivotal-cf/om/api/fakes"
+	omfakes "github.com/pivotal-cf/om/commands/fakes"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Upload", func() {
+	Describe("Execute", func() {
+		var (
+			server    *testSSHServer
+			tmpDir    string
+			localFile string
+			progress  *apifakes.Progress
+			barred    *bytes.Buffer
+			stdout    *omfakes.Logger
+			stderr    *omfakes.Logger
+			command   commands.Upload
+			args      []string
+		)
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "upload")
+			Expect(err).ToNot(HaveOccurred())
+
+			localFile = filepath.Join(tmpDir, "stemcell.tgz")
+			Expect(ioutil.WriteFile(localFile, []byte("light stemcell"), 0644)).To(Succeed())
+
+			server = newTestSSHServer()
+			server.handler = server.runInShell(tmpDir)
+
+			barred = &bytes.Buffer{}
+			progress = &apifakes.Progress{}
+			progress.NewBarReaderStub = func(r io.Reader) io.Reader {
+				return io.TeeReader(r, barred)
+			}
+			stdout = &omfakes.Logger{}
+			stderr = &omfakes.Logger{}
+
+			command = commands.NewUploadCommand(commands.NewSSHClient(stdout, stdout), "127.0.0.1", progress, stdout, stderr)
+			args = []string{
+				"--ssh-password", "secret",
+				"--ssh-port", strconv.Itoa(server.port()),
+				"--ssh-host-key-fingerprint", server.fingerprint(),
+				"--file", localFile,
+				"--remote-path", filepath.Join(tmpDir, "uploaded.tgz"),
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(tmpDir)
+		})
+
+		It("uploads the file and verifies its checksum", func() {
+			Expect(command.Execute(args)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(tmpDir, "uploaded.tgz"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("light stemcell"))
+
+			Expect(progress.SetTotalArgsForCall(0)).To(Equal(int64(len("light stemcell"))))
+			Expect(progress.KickoffCallCount()).To(Equal(1))
+			Expect(progress.EndCallCount()).To(Equal(1))
+			Expect(barred.String()).To(Equal("light stemcell"))
+
+			Expect(server.Commands()).To(ContainElement(ContainSubstring("sha256sum")))
+
+			format, v := stdout.PrintfArgsForCall(stdout.PrintfCallCount() - 1)
+			Expect(fmt.Sprintf(format, v...)).To(Equal(fmt.Sprintf(
+				"Uploaded %s to %s (sha256 2b1d8a00a530092cc301ec9cb15bb714dccb5b0f144adba36f841b71fd4dd160)\n",
+				localFile, filepath.Join(tmpDir, "uploaded.tgz"),
+			)))
+		})
+
+		It("resumes from a partial upload left on the remote", func() {
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "uploaded.tgz.part"), []byte("light "), 0644)).To(Succeed())
+
+			Expect(command.Execute(args)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(tmpDir, "uploaded.tgz"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("light stemcell"))
+			Expect(filepath.Join(tmpDir, "uploaded.tgz.part")).ToNot(BeAnExistingFile())
+
+			Expect(server.Commands()).To(ContainElement(HavePrefix("cat >> ")))
+			Expect(progress.SetTotalArgsForCall(0)).To(Equal(int64(len("light stemcell"))))
+			Expect(barred.Len()).To(Equal(len("light stemcell")))
+
+			format, v := stderr.PrintfArgsForCall(0)
+			Expect(fmt.Sprintf(format, v...)).To(Equal(fmt.Sprintf("Resuming upload of %s at byte 6\n", localFile)))
+		})
+
+		It("starts again when the checksums do not match", func() {
+			shell := server.handler
+			lied := false
+			server.handler = func(command string, channel ssh.Channel) uint32 {
+				if strings.HasPrefix(command, "sha256sum") && !lied {
+					lied = true
+					io.WriteString(channel, "0000  uploaded.tgz\n")
+					return 0
+				}
+				return shell(command, channel)
+			}
+
+			Expect(command.Execute(append(args, "--transfer-retry-delay", "1ms"))).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(tmpDir, "uploaded.tgz"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("light stemcell"))
+
+			Expect(progress.KickoffCallCount()).To(Equal(1))
+			Expect(progress.EndCallCount()).To(Equal(1))
+			Expect(barred.Len()).To(Equal(len("light stemcell")))
+			format, v := stderr.PrintfArgsForCall(0)
+			Expect(fmt.Sprintf(format, v...)).To(HavePrefix("Transfer attempt 1 of 6 failed: checksum mismatch"))
+		})
+
+		It("gives up when the checksums keep not matching", func() {
+			shell := server.handler
+			server.handler = func(command string, channel ssh.Channel) uint32 {
+				if strings.HasPrefix(command, "sha256sum") {
+					io.WriteString(channel, "0000  uploaded.tgz\n")
+					return 0
+				}
+				return shell(command, channel)
+			}
+
+			err := command.Execute(append(args, "--transfer-retries", "2", "--transfer-retry-delay", "1ms"))
+			Expect(err).To(BeAssignableToTypeOf(commands.ChecksumMismatchError{}))
+			Expect(err.(commands.ChecksumMismatchError).Actual).To(Equal("0000"))
+
+			Expect(progress.KickoffCallCount()).To(Equal(1))
+			Expect(progress.EndCallCount()).To(Equal(1))
+			Expect(stderr.PrintfCallCount()).To(Equal(2))
+			Expect(filepath.Join(tmpDir, "uploaded.tgz.part")).ToNot(BeAnExistingFile())
+		})
+
+		It("returns the error when the file cannot be written", func() {
+			args[len(args)-1] = filepath.Join(tmpDir, "missing", "uploaded.tgz")
+
+			err := command.Execute(args)
+			Expect(err).To(BeAssignableToTypeOf(commands.RemoteExitError{}))
+		})
+
+		It("fails when no file is given", func() {
+			sshClient := &fakes.SSHClient{}
+			command = commands.NewUploadCommand(sshClient, "127.0.0.1", progress, stdout, stderr)
+
+			err := command.Execute([]string{"--ssh-password", "secret"})
+			Expect(err).To(MatchError("a file to upload must be provided"))
+			Expect(sshClient.ConnectCallCount()).To(Equal(0))
+		})
+	})
+})
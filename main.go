@@ -25,6 +25,7 @@ import (
 	"github.com/pivotal-cf/om/api"
 	omcommands "github.com/pivotal-cf/om/commands"
 	"github.com/pivotal-cf/om/flags"
+	"github.com/pivotal-cf/om/progress"
 
 	"github.com/pivotal-cf/om/network"
 )
@@ -67,9 +68,43 @@ func main() {
 	}
 
 	commandSet := omcommands.Set{}
-	commandSet["bosh"] = commands.NewBoshCommand(requestService, sshClient, uri.Host, stdout, stderr)
-	err = commandSet.Execute(command, args)
+	commandSet["bosh"] = commands.NewBoshCommand(requestService, sshClient, uri.Hostname(), stdout, stderr)
+	commandSet["upload"] = commands.NewUploadCommand(sshClient, uri.Hostname(), progress.NewBar(), stdout, stderr)
+	commandSet["products"] = commands.NewDeployedProductsCommand(requestService, stdout)
+	commandSet["bosh-env"] = commands.NewBoshEnvCommand(requestService, api.NewSecurityService(authedClient), stdout)
+	commandSet["tunnel"] = commands.NewTunnelCommand(requestService, sshClient, uri.Hostname(), stdout, stderr)
+	commandSet["forward"] = commands.NewForwardCommand(sshClient, uri.Hostname(), stderr)
+	commandSet["download"] = commands.NewDownloadCommand(sshClient, uri.Hostname(), progress.NewBar(), stdout, stderr)
+
+	// omcommands.Set.Execute flattens errors into strings, so run the command
+	// directly to keep the remote exit status.
+	cmd, ok := commandSet[command]
+	if !ok {
+		stdout.Fatalf("unknown command: %s", command)
+	}
+
+	err = cmd.Execute(args)
 	if err != nil {
-		stdout.Fatal(err)
+		stdout.Printf("could not execute %q: %s", command, err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode mirrors ssh(1): the remote command's exit status is passed through
+// and failures of the ssh connection itself exit with 255. Interrupted runs
+// exit with 128 plus the signal number, and batches with the status of their
+// first failure.
+func exitCode(err error) int {
+	switch err := err.(type) {
+	case commands.RemoteExitError:
+		return err.ExitStatus
+	case commands.InterruptedError:
+		return err.ExitStatus()
+	case commands.BatchError:
+		return exitCode(err.Err)
+	case commands.DialError, commands.AuthError, commands.SessionError, commands.ConnectionLostError:
+		return 255
+	default:
+		return 1
 	}
 }